@@ -0,0 +1,657 @@
+package xmlstreamer
+
+import (
+	"encoding"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wilkmaciej/xpath"
+)
+
+var (
+	xmlNameType         = reflect.TypeFor[xml.Name]()
+	xmlAttrType         = reflect.TypeFor[xml.Attr]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+var errDecodeNilPointer = errors.New("xmlstreamer: Decode requires a non-nil pointer")
+
+// structDecoderCache maps a struct type to its *structDecoder
+var structDecoderCache sync.Map
+
+// Decode stores the content of the element in the value pointed to by v, following
+// the rules of encoding/xml's Unmarshal for struct fields tagged with `xml:"..."`:
+//
+//	xml:"name"           child elements with the local name (or "URI name" to also match the namespace)
+//	xml:"a>b>c"          elements nested in the given children
+//	xml:"name,attr"      the attribute (or "URI name,attr")
+//	xml:",chardata"      the element's own character data (",cdata" is the same)
+//	xml:",innerxml"      the element's children re-serialized as in InnerXML
+//	xml:",comment"       the element's comments
+//	xml:",any"           child elements not matched by another field
+//	xml:",any,attr"      attributes not matched by another field
+//	xml:"-"              the field is ignored
+//
+// Untagged exported fields match child elements with the field's name, and an
+// XMLName field of type xml.Name receives the element's name (and, if tagged,
+// requires it). Fields may be strings, []byte, booleans, numbers, types implementing
+// encoding.TextUnmarshaler or xml.Unmarshaler, structs, and pointers or slices of
+// these; slices receive every match, other fields the last one. Attribute fields may
+// also be xml.Attr or implement xml.UnmarshalerAttr.
+//
+// A field tagged `xpath:"expr"` is instead set from the result of evaluating expr
+// with the element as context node: node-sets fill slices or set the first node's
+// value, and string, number and boolean results are converted to the field's type.
+// Expressions are compiled once per struct type.
+//
+// Decoded values do not reference the element, so it can be released afterwards.
+// Values that cannot be converted are reported as *DecodeError.
+func (e *XMLElement) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errDecodeNilPointer
+	}
+	return decodeElement(e, rv.Elem())
+}
+
+type fieldKind uint8
+
+const (
+	fieldElement fieldKind = iota
+	fieldAttr
+	fieldCharData
+	fieldInnerXML
+	fieldComment
+	fieldAny
+	fieldAnyAttr
+	fieldXPath
+	fieldXMLName
+)
+
+// fieldDecoder describes how one struct field is filled
+type fieldDecoder struct {
+	index []int  // field index, with the path through embedded structs
+	name  string // Go field name, for errors
+	kind  fieldKind
+
+	// Name test for element, attribute and XMLName fields; space is a namespace
+	// URI, or "" to match the local name in any namespace
+	space   string
+	local   string
+	parents []string // local names of the enclosing elements of an a>b>c path
+
+	// Compiled expression of an xpath field. Expressions keep evaluation state
+	// and cannot be shared between goroutines, so each use takes one from the pool.
+	exprs *sync.Pool
+}
+
+// structDecoder holds the field decoders of a struct type
+type structDecoder struct {
+	fields []fieldDecoder
+	err    error // set if the type's tags are invalid
+}
+
+// cachedStructDecoder returns the decoder of the struct type t, building it on first use
+func cachedStructDecoder(t reflect.Type) (*structDecoder, error) {
+	if sd, ok := structDecoderCache.Load(t); ok {
+		return sd.(*structDecoder), sd.(*structDecoder).err
+	}
+	sd := &structDecoder{}
+	sd.err = sd.addFields(t, nil)
+	actual, _ := structDecoderCache.LoadOrStore(t, sd)
+	return actual.(*structDecoder), actual.(*structDecoder).err
+}
+
+// addFields adds the fields of struct type t, reached through index, to sd
+func (sd *structDecoder) addFields(t reflect.Type, index []int) error {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if expr, ok := f.Tag.Lookup("xpath"); ok {
+			if _, err := xpath.Compile(expr); err != nil {
+				return fmt.Errorf("xmlstreamer: invalid xpath tag on %s.%s: %w", t, f.Name, err)
+			}
+			sd.fields = append(sd.fields, fieldDecoder{
+				index: fieldIndex,
+				name:  f.Name,
+				kind:  fieldXPath,
+				exprs: &sync.Pool{New: func() any { return xpath.MustCompile(expr) }},
+			})
+			continue
+		}
+
+		tag, hasTag := f.Tag.Lookup("xml")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if !hasTag && ft.Kind() == reflect.Struct {
+				// Fields of embedded structs are promoted, as in encoding/xml
+				if err := sd.addFields(ft, fieldIndex); err != nil {
+					return err
+				}
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+		}
+
+		fd, err := parseFieldTag(f, tag)
+		if err != nil {
+			return fmt.Errorf("xmlstreamer: invalid xml tag on %s.%s: %w", t, f.Name, err)
+		}
+		fd.index = fieldIndex
+		sd.fields = append(sd.fields, fd)
+	}
+	return nil
+}
+
+// parseFieldTag interprets the xml tag of a struct field
+func parseFieldTag(f reflect.StructField, tag string) (fieldDecoder, error) {
+	fd := fieldDecoder{name: f.Name, kind: fieldElement}
+
+	name, flags, _ := strings.Cut(tag, ",")
+	for flag := range strings.SplitSeq(flags, ",") {
+		kind := fd.kind
+		switch flag {
+		case "", "omitempty":
+			continue
+		case "attr":
+			kind = fieldAttr
+		case "chardata", "cdata":
+			kind = fieldCharData
+		case "innerxml":
+			kind = fieldInnerXML
+		case "comment":
+			kind = fieldComment
+		case "any":
+			kind = fieldAny
+		default:
+			return fd, fmt.Errorf("unsupported flag %q", flag)
+		}
+		switch {
+		case fd.kind == fieldElement:
+		case fd.kind == fieldAny && kind == fieldAttr, fd.kind == fieldAttr && kind == fieldAny:
+			kind = fieldAnyAttr
+		default:
+			return fd, fmt.Errorf("conflicting flags %q", flags)
+		}
+		fd.kind = kind
+	}
+
+	if space, local, ok := strings.Cut(name, " "); ok {
+		fd.space, name = space, local
+	}
+	if f.Name == "XMLName" {
+		if f.Type != xmlNameType {
+			return fd, errors.New("XMLName must be of type xml.Name")
+		}
+		fd.kind = fieldXMLName
+		fd.local = name
+		return fd, nil
+	}
+
+	switch fd.kind {
+	case fieldElement:
+		if name == "" {
+			name = f.Name
+		}
+		parts := strings.Split(name, ">")
+		for _, part := range parts {
+			if part == "" {
+				return fd, fmt.Errorf("invalid element path %q", name)
+			}
+		}
+		fd.parents, fd.local = parts[:len(parts)-1], parts[len(parts)-1]
+	case fieldAttr:
+		if name == "" {
+			name = f.Name
+		}
+		fd.local = name
+	default:
+		if name != "" || fd.space != "" {
+			return fd, fmt.Errorf("name %q not allowed with flags %q", name, flags)
+		}
+	}
+	return fd, nil
+}
+
+// decodeElement stores elem in v, which may be a struct, a scalar, or a pointer or slice of those
+func decodeElement(elem *XMLElement, v reflect.Value) error {
+	v = allocate(v)
+	if handled, err := unmarshalElement(elem, v); handled {
+		return err
+	}
+	switch {
+	case v.Kind() == reflect.Struct:
+		sd, err := cachedStructDecoder(v.Type())
+		if err != nil {
+			return err
+		}
+		return sd.decode(elem, v)
+	case isRepeated(v):
+		item := reflect.New(v.Type().Elem()).Elem()
+		if err := decodeElement(elem, item); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, item))
+		return nil
+	}
+	if err := setText(v, charData(elem)); err != nil {
+		return &DecodeError{Position: elem.position, Err: err}
+	}
+	return nil
+}
+
+// decode fills the fields of the struct v from elem
+func (sd *structDecoder) decode(elem *XMLElement, v reflect.Value) error {
+	for i := range sd.fields {
+		fd := &sd.fields[i]
+		if err := sd.decodeField(elem, fd, fieldByIndex(v, fd.index)); err != nil {
+			var decodeErr *DecodeError
+			if errors.As(err, &decodeErr) {
+				// Prefix the path of nested fields with the name of this one
+				if decodeErr.Field == "" {
+					decodeErr.Field = fd.name
+				} else {
+					decodeErr.Field = fd.name + "." + decodeErr.Field
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (sd *structDecoder) decodeField(elem *XMLElement, fd *fieldDecoder, v reflect.Value) error {
+	switch fd.kind {
+	case fieldXMLName:
+		if fd.local != "" && (fd.local != elem.localName || fd.space != "" && fd.space != elem.namespaceURI) {
+			return &DecodeError{
+				Position: elem.position,
+				Err:      fmt.Errorf("expected element <%s> but have <%s>", fd.local, elem.Name),
+			}
+		}
+		v.Set(reflect.ValueOf(xml.Name{Space: elem.namespaceURI, Local: elem.localName}))
+
+	case fieldAttr:
+		for i := range elem.Attributes {
+			attr := &elem.Attributes[i]
+			name := attr.xmlName()
+			if name.Local != fd.local || fd.space != "" && fd.space != name.Space {
+				continue
+			}
+			if err := setAttr(v, xml.Attr{Name: name, Value: attr.Value}); err != nil {
+				return &DecodeError{Position: elem.position, Err: fmt.Errorf("attribute %s: %w", attr.Name, err)}
+			}
+		}
+
+	case fieldCharData:
+		if err := setText(v, charData(elem)); err != nil {
+			return &DecodeError{Position: elem.position, Err: err}
+		}
+
+	case fieldInnerXML:
+		if err := setText(v, elem.InnerXML()); err != nil {
+			return &DecodeError{Position: elem.position, Err: err}
+		}
+
+	case fieldComment:
+		var sb strings.Builder
+		for _, child := range elem.children {
+			if c, ok := child.(*XMLContentNode); ok && c.nodeType == xpath.CommentNode {
+				sb.WriteString(c.InnerText())
+			}
+		}
+		if err := setText(v, sb.String()); err != nil {
+			return &DecodeError{Position: elem.position, Err: err}
+		}
+
+	case fieldElement:
+		return fd.decodeChildren(elem, 0, v)
+
+	case fieldAny:
+		for _, child := range elem.children {
+			if c, ok := child.(*XMLElement); ok && !sd.matchesElementField(c) {
+				if err := decodeElement(c, v); err != nil {
+					return err
+				}
+			}
+		}
+
+	case fieldAnyAttr:
+		for i := range elem.Attributes {
+			attr := &elem.Attributes[i]
+			name := attr.xmlName()
+			if sd.matchesAttrField(name) {
+				continue
+			}
+			if err := setAttr(v, xml.Attr{Name: name, Value: attr.Value}); err != nil {
+				return &DecodeError{Position: elem.position, Err: fmt.Errorf("attribute %s: %w", attr.Name, err)}
+			}
+		}
+
+	case fieldXPath:
+		expr := fd.exprs.Get().(*xpath.Expr)
+		result := elem.Evaluate(expr)
+		fd.exprs.Put(expr)
+		if err := setXPathResult(v, result); err != nil {
+			var decodeErr *DecodeError
+			if errors.As(err, &decodeErr) {
+				return err
+			}
+			return &DecodeError{Position: elem.position, Err: err}
+		}
+	}
+	return nil
+}
+
+// decodeChildren decodes the children of elem matching step depth of fd's element path
+func (fd *fieldDecoder) decodeChildren(elem *XMLElement, depth int, v reflect.Value) error {
+	for _, child := range elem.children {
+		c, ok := child.(*XMLElement)
+		if !ok {
+			continue
+		}
+		if depth < len(fd.parents) {
+			if c.localName == fd.parents[depth] {
+				if err := fd.decodeChildren(c, depth+1, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if c.localName == fd.local && (fd.space == "" || fd.space == c.namespaceURI) {
+			if err := decodeElement(c, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchesElementField reports whether a child element is claimed by one of the element fields
+func (sd *structDecoder) matchesElementField(child *XMLElement) bool {
+	for i := range sd.fields {
+		fd := &sd.fields[i]
+		if fd.kind != fieldElement {
+			continue
+		}
+		if len(fd.parents) > 0 {
+			if child.localName == fd.parents[0] {
+				return true
+			}
+		} else if child.localName == fd.local && (fd.space == "" || fd.space == child.namespaceURI) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAttrField reports whether an attribute is claimed by one of the attribute fields
+func (sd *structDecoder) matchesAttrField(name xml.Name) bool {
+	for i := range sd.fields {
+		fd := &sd.fields[i]
+		if fd.kind == fieldAttr && fd.local == name.Local && (fd.space == "" || fd.space == name.Space) {
+			return true
+		}
+	}
+	return false
+}
+
+// xmlName returns the name of the attribute as encoding/xml reports it, which
+// puts namespace declarations in the space "xmlns" (or none for xmlns itself)
+func (attr *XMLAttribute) xmlName() xml.Name {
+	if attr.NamespaceURI == xmlnsNamespaceURI {
+		if attr.Name == "xmlns" {
+			return xml.Name{Local: "xmlns"}
+		}
+		return xml.Name{Space: "xmlns", Local: attr.LocalName}
+	}
+	return xml.Name{Space: attr.NamespaceURI, Local: attr.LocalName}
+}
+
+// charData returns a copy of the text and CDATA children of elem, excluding
+// the text of nested elements
+func charData(elem *XMLElement) string {
+	var sb strings.Builder
+	for _, child := range elem.children {
+		if c, ok := child.(*XMLContentNode); ok && c.nodeType == xpath.TextNode {
+			sb.WriteString(c.InnerText())
+		}
+	}
+	return sb.String()
+}
+
+// setXPathResult stores the result of XMLElement.Evaluate in v
+func setXPathResult(v reflect.Value, result any) error {
+	switch r := result.(type) {
+	case []any:
+		if len(r) == 0 {
+			return nil
+		}
+		target := allocate(v)
+		if isRepeated(target) && !implements(target, textUnmarshalerType) {
+			for _, node := range r {
+				item := reflect.New(target.Type().Elem()).Elem()
+				if err := setXPathNode(item, node); err != nil {
+					return err
+				}
+				target.Set(reflect.Append(target, item))
+			}
+			return nil
+		}
+		return setXPathNode(v, r[0])
+	case string:
+		return setText(v, strings.Clone(r))
+	case float64:
+		target := allocate(v)
+		switch target.Kind() {
+		case reflect.Float32, reflect.Float64:
+			target.SetFloat(r)
+			return nil
+		}
+		return setText(v, strconv.FormatFloat(r, 'f', -1, 64))
+	case bool:
+		return setText(v, strconv.FormatBool(r))
+	}
+	return fmt.Errorf("unexpected xpath result %T", result)
+}
+
+// setXPathNode stores one node of an xpath node-set in v. Elements decode into
+// structs; otherwise nodes are converted from their string value.
+func setXPathNode(v reflect.Value, node any) error {
+	switch n := node.(type) {
+	case *XMLElement:
+		target := allocate(v)
+		if target.Kind() == reflect.Struct && !implements(target, textUnmarshalerType) {
+			return decodeElement(n, target)
+		}
+		if err := setText(v, strings.Clone(n.InnerText())); err != nil {
+			return &DecodeError{Position: n.position, Err: err}
+		}
+	case *XMLContentNode:
+		if err := setText(v, strings.Clone(n.InnerText())); err != nil {
+			return &DecodeError{Position: n.position, Err: err}
+		}
+	case *XMLAttribute:
+		return setText(v, n.Value)
+	}
+	return nil
+}
+
+// unmarshalElement passes elem to the xml.Unmarshaler or encoding.TextUnmarshaler
+// implementation of v, if there is one
+func unmarshalElement(elem *XMLElement, v reflect.Value) (bool, error) {
+	if !v.CanAddr() {
+		return false, nil
+	}
+	switch u := v.Addr().Interface().(type) {
+	case xml.Unmarshaler:
+		d := xml.NewDecoder(strings.NewReader(elem.OuterXML()))
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return true, &DecodeError{Position: elem.position, Err: err}
+			}
+			if start, ok := tok.(xml.StartElement); ok {
+				if err := u.UnmarshalXML(d, start); err != nil {
+					return true, &DecodeError{Position: elem.position, Err: err}
+				}
+				return true, nil
+			}
+		}
+	case encoding.TextUnmarshaler:
+		if err := u.UnmarshalText([]byte(charData(elem))); err != nil {
+			return true, &DecodeError{Position: elem.position, Err: err}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// setAttr stores an attribute value in v
+func setAttr(v reflect.Value, attr xml.Attr) error {
+	v = allocate(v)
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(xml.UnmarshalerAttr); ok {
+			return u.UnmarshalXMLAttr(attr)
+		}
+	}
+	switch {
+	case v.Type() == xmlAttrType:
+		v.Set(reflect.ValueOf(attr))
+		return nil
+	case isRepeated(v) && !implements(v, textUnmarshalerType):
+		item := reflect.New(v.Type().Elem()).Elem()
+		if err := setAttr(item, attr); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, item))
+		return nil
+	}
+	return setText(v, attr.Value)
+}
+
+// setText converts s to the type of v and stores it, appending to slices.
+// As in encoding/xml, surrounding space is ignored for numbers and booleans,
+// and empty strings store the zero value.
+func setText(v reflect.Value, s string) error {
+	v = allocate(v)
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+
+	if isRepeated(v) {
+		item := reflect.New(v.Type().Elem()).Elem()
+		if err := setText(item, s); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, item))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Slice: // []byte
+		v.SetBytes([]byte(s))
+		return nil
+	}
+
+	s = strings.TrimSpace(s)
+	switch v.Kind() {
+	case reflect.Bool:
+		if s == "" {
+			v.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if s == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if s == "" {
+			v.SetUint(0)
+			return nil
+		}
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if s == "" {
+			v.SetFloat(0)
+			return nil
+		}
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// allocate follows pointers from v, allocating nil ones, and returns the value they point to
+func allocate(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// isRepeated reports whether v is a slice that receives every match, i.e. not []byte
+func isRepeated(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8
+}
+
+// implements reports whether a pointer to v implements the interface type iface
+func implements(v reflect.Value, iface reflect.Type) bool {
+	return reflect.PointerTo(v.Type()).Implements(iface)
+}
+
+// fieldByIndex returns the field of struct v at index, allocating embedded struct pointers
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 {
+			v = allocate(v)
+		}
+		v = v.Field(x)
+	}
+	return v
+}
@@ -0,0 +1,82 @@
+package xmlstreamer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Magic bytes at the start of the supported compressed formats
+var (
+	gzipMagic  = []byte{0x1F, 0x8B}
+	zstdMagic  = []byte{0x28, 0xB5, 0x2F, 0xFD}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// NewParserFromFile creates a parser that reads the file at path, decompressing it
+// if it is compressed (see WithAutoDecompress). The file is closed when parsing
+// ends or Close is called. opts configure the parser as for NewParserWithOptions.
+func NewParserFromFile(ctx context.Context, path string, opts ...Option) (*Parser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := NewParserWithOptions(ctx, f, append([]Option{WithAutoDecompress()}, opts...)...)
+	p.inputCloser = f
+	return p, nil
+}
+
+// decompressInput detects whether r is compressed with gzip, zstd, bzip2 or xz from
+// its first bytes and returns a reader of the decompressed data, together with a
+// function that releases the decompressor. Other input is returned unchanged.
+func decompressInput(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	noop := func() {}
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		// Concatenated gzip members are read as one stream
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gzip: %w", err)
+		}
+		return zr, func() { _ = zr.Close() }, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zstd: %w", err)
+		}
+		return zr, zr.Close, nil
+	case bytes.HasPrefix(head, bzip2Magic) && len(head) > 3 && head[3] >= '1' && head[3] <= '9':
+		return bzip2.NewReader(br), noop, nil
+	case bytes.HasPrefix(head, xzMagic):
+		zr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("xz: %w", err)
+		}
+		return zr, noop, nil
+	}
+	return br, noop, nil
+}
+
+// closeInput closes the input opened by NewParserFromFile, if any
+func (p *Parser) closeInput() {
+	p.closeOnce.Do(func() {
+		if p.inputCloser != nil {
+			_ = p.inputCloser.Close()
+		}
+	})
+}
@@ -12,15 +12,19 @@ import (
 type XMLNode interface {
 	Parent() *XMLElement
 	InnerText() string
+	Position() Position
 	getSiblingIndex() int
 }
 
-// XMLContentNode represents a text or comment node in the XML tree
+// XMLContentNode represents a text, comment or processing instruction node in the XML tree
 // Content is stored as offsets into parent's rawContent buffer for zero-copy access
 type XMLContentNode struct {
 	start        int // start offset in parent.rawContent
 	end          int // end offset in parent.rawContent
 	nodeType     xpath.NodeType
+	cdata        bool     // text node that came from a CDATA section
+	target       string   // target of a processing instruction node
+	position     Position // input position of the first byte of the node
 	parent       *XMLElement
 	siblingIndex int // index within parent's children slice for O(1) sibling navigation
 }
@@ -39,6 +43,20 @@ func (c *XMLContentNode) InnerText() string {
 	return unsafe.String(&c.parent.rawContent[c.start], c.end-c.start)
 }
 
+// Target returns the target of a processing instruction node, e.g. "xml-stylesheet"
+// for <?xml-stylesheet href="style.css"?>, whose InnerText is its data. It returns
+// "" for text and comment nodes. See Evaluate for selecting processing instructions
+// with XPath.
+func (c *XMLContentNode) Target() string {
+	return c.target
+}
+
+// Position returns the position in the input where the node starts.
+// For CDATA sections, comments and processing instructions this is the '<' of the markup.
+func (c *XMLContentNode) Position() Position {
+	return c.position
+}
+
 // getSiblingIndex returns the index within parent's children
 func (c *XMLContentNode) getSiblingIndex() int {
 	return c.siblingIndex
@@ -58,12 +76,73 @@ type XMLElement struct {
 	namespaces   map[string]string // prefix -> URI mapping for this element's scope
 	siblingIndex int               // index within parent's children slice for O(1) sibling navigation
 	rawContent   []byte            // Raw byte buffer for text content (children reference slices of this)
+	streamed     bool              // Set by the parser when this element is emitted to the consumer
+	pending      []*predicate      // Filter conditions to check when the element is closed
+	position     Position          // Input position of the '<' of the start tag
+	endOffset    int64             // Input offset just past the end tag
+	raw          []byte            // Source bytes of a streamed element, kept with WithRawCapture
 }
 
 // XMLAttribute represents an XML attribute
 type XMLAttribute struct {
-	Name  string
+	Name  string // qualified name as written, e.g. "g:id"
 	Value string
+
+	// LocalName is Name without its prefix. NamespaceURI is the namespace bound to
+	// the prefix where the attribute appears: "" for unprefixed attributes, and
+	// "http://www.w3.org/2000/xmlns/" for namespace declarations.
+	LocalName    string
+	NamespaceURI string
+}
+
+// processingInstructionNode is the node type reported to xpath for processing
+// instructions. The xpath package has no constant for it; the value is kept well
+// clear of the package's own node types so that no node test but node() matches
+// processing instructions.
+const processingInstructionNode xpath.NodeType = 64
+
+// Namespace URIs bound to the reserved prefixes xml and xmlns
+const (
+	xmlNamespaceURI   = "http://www.w3.org/XML/1998/namespace"
+	xmlnsNamespaceURI = "http://www.w3.org/2000/xmlns/"
+)
+
+// LocalName returns the element name without its prefix
+func (e *XMLElement) LocalName() string {
+	return e.localName
+}
+
+// Prefix returns the namespace prefix of the element name, or "" if it has none
+func (e *XMLElement) Prefix() string {
+	return e.prefix
+}
+
+// NamespaceURI returns the namespace of the element, or "" if it is in no namespace
+func (e *XMLElement) NamespaceURI() string {
+	return e.namespaceURI
+}
+
+// LookupNamespace returns the namespace URI bound to prefix in the scope of the
+// element; prefix "" looks up the default namespace. The xml prefix is always bound.
+func (e *XMLElement) LookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespaceURI, true
+	}
+	uri, ok := e.namespaces[prefix]
+	// xmlns="" undeclares the default namespace
+	return uri, ok && uri != ""
+}
+
+// InScopeNamespaces returns the prefix to namespace URI bindings in scope for
+// the element, with "" for the default namespace. The map is a copy.
+func (e *XMLElement) InScopeNamespaces() map[string]string {
+	namespaces := make(map[string]string, len(e.namespaces))
+	for prefix, uri := range e.namespaces {
+		if uri != "" {
+			namespaces[prefix] = uri
+		}
+	}
+	return namespaces
 }
 
 // Parent returns the parent element
@@ -76,21 +155,48 @@ func (e *XMLElement) getSiblingIndex() int {
 	return e.siblingIndex
 }
 
+// Raw returns the source bytes of the element, from the '<' of its start tag to the
+// '>' of its end tag, exactly as they were read. It is only set on streamed elements
+// of a parser created with WithRawCapture and returns nil otherwise. Since input in
+// an encoding other than UTF-8 is converted before parsing, raw capture refuses it.
+func (e *XMLElement) Raw() []byte {
+	return e.raw
+}
+
+// Position returns the offset, line and column of the '<' of the element's start tag
+func (e *XMLElement) Position() Position {
+	return e.position
+}
+
+// StartOffset returns the byte offset in the input of the '<' of the element's start tag.
+// For input converted to UTF-8 from another encoding, this and EndOffset are offsets
+// into the converted text, not into the input bytes.
+func (e *XMLElement) StartOffset() int64 {
+	return e.position.Offset
+}
+
+// EndOffset returns the byte offset in the input just past the '>' of the element's
+// end tag (or of its start tag, if it is self-closing), so that the element's source
+// is the input range [StartOffset, EndOffset)
+func (e *XMLElement) EndOffset() int64 {
+	return e.endOffset
+}
+
 // InnerText returns the concatenated text content of this element and all descendants
 func (e *XMLElement) InnerText() string {
 	if len(e.children) == 0 {
 		return ""
 	}
 
-	// Fast path: if no nested element children, rawContent is the complete text
-	hasElementChild := false
+	// Fast path: if all children are text, rawContent is the complete text
+	textOnly := true
 	for _, child := range e.children {
-		if _, ok := child.(*XMLElement); ok {
-			hasElementChild = true
+		if c, ok := child.(*XMLContentNode); !ok || c.nodeType != xpath.TextNode {
+			textOnly = false
 			break
 		}
 	}
-	if !hasElementChild {
+	if textOnly {
 		// Zero-copy: rawContent contains all text content for this element
 		return unsafe.String(unsafe.SliceData(e.rawContent), len(e.rawContent))
 	}
@@ -109,7 +215,7 @@ func (e *XMLElement) collectText(sb *strings.Builder) {
 			if node.nodeType == xpath.TextNode && node.parent != nil && node.start < node.end {
 				sb.Write(node.parent.rawContent[node.start:node.end])
 			}
-			// Skip comment nodes in text collection
+			// Skip comment and processing instruction nodes in text collection
 		case *XMLElement:
 			node.collectText(sb)
 		}
@@ -122,6 +228,10 @@ func (e *XMLElement) collectText(sb *strings.Builder) {
 //   - String functions return string
 //   - Numeric functions (count, sum, etc.) return float64
 //   - Boolean expressions return bool
+//
+// The xpath package does not implement the processing-instruction() node test: it
+// matches child elements instead, by name if a target is given, and never
+// processing instructions. Select those with node()[name() = 'target' and not(self::*)].
 func (e *XMLElement) Evaluate(exp *xpath.Expr) any {
 	nav := &elementNavigator{currNode: e, currElement: e, root: e, attributeIndex: -1}
 	result := exp.Evaluate(nav)
@@ -145,6 +255,19 @@ func (e *XMLElement) Evaluate(exp *xpath.Expr) any {
 	return result
 }
 
+// EvaluateWithNamespaces compiles expr with the given prefix to namespace URI
+// bindings and evaluates it like Evaluate. Prefixed names in expr then match by
+// namespace URI, whatever prefix the document uses. The expression is compiled on
+// every call; in loops, compile it once with xpath.CompileWithNS (or
+// Parser.CompileXPath) and use Evaluate.
+func (e *XMLElement) EvaluateWithNamespaces(expr string, namespaces map[string]string) (any, error) {
+	exp, err := xpath.CompileWithNS(expr, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	return e.Evaluate(exp), nil
+}
+
 // Release returns this element and all its children back to the pool for reuse.
 // IMPORTANT: After calling Release(), you must not use this element or any of its
 // children anymore, as they may be reused by the parser. Only call this when you're
@@ -211,6 +334,9 @@ func returnElementToPool(elem *XMLElement) {
 		current.Attributes = current.Attributes[:0]
 		current.namespaces = nil
 		current.siblingIndex = 0
+		current.streamed = false
+		current.pending = nil
+		current.raw = nil
 		current.rawContent = current.rawContent[:0] // Keep backing array
 		xmlElementPool.Put(current)
 	}
@@ -0,0 +1,114 @@
+package xmlstreamer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// ErrUnsupportedEncoding is wrapped by the *ParseError reported when the document
+// declares an encoding that cannot be decoded
+var ErrUnsupportedEncoding = errors.New("unsupported encoding")
+
+// errRawCaptureEncoding reports input that WithRawCapture cannot keep the source bytes
+// of, because it has been converted to UTF-8 from the named encoding
+func errRawCaptureEncoding(name string) error {
+	return fmt.Errorf("%w %q with raw capture: Raw would return the UTF-8 conversion, not the input bytes", ErrUnsupportedEncoding, name)
+}
+
+// CharsetReader returns a reader that converts input from the character encoding
+// named by label to UTF-8. It has the signature of encoding/xml.Decoder.CharsetReader.
+type CharsetReader func(label string, input io.Reader) (io.Reader, error)
+
+// Limit on how far the XML declaration is searched for its end
+const maxDeclarationSize = 1024
+
+// decodeInput detects the encoding of r from its byte order mark or XML declaration
+// and returns a reader of r converted to UTF-8, together with the name of the
+// encoding converted from. UTF-8 input is returned unchanged, with an empty name,
+// except that a UTF-8 byte order mark is consumed; bomLen is its length.
+func (p *Parser) decodeInput(r io.Reader) (decoded io.Reader, converted string, bomLen int, err error) {
+	br := bufio.NewReaderSize(r, maxDeclarationSize)
+	head, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, "", 0, err
+	}
+
+	// UTF-16 is recognized by its byte order mark, or by how "<?" is encoded
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Reader(br), "UTF-16BE", 0, nil
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Reader(br), "UTF-16LE", 0, nil
+	case bytes.Equal(head, []byte{0, '<', 0, '?'}):
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder().Reader(br), "UTF-16BE", 0, nil
+	case bytes.Equal(head, []byte{'<', 0, '?', 0}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().Reader(br), "UTF-16LE", 0, nil
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		// gosax would report it as text; the declaration follows it
+		bomLen, _ = br.Discard(3)
+		return br, "", bomLen, nil
+	}
+
+	label, err := peekDeclaredEncoding(br)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	switch {
+	case label == "" || strings.EqualFold(label, "UTF-8"):
+		return br, "", 0, nil
+	case p.charsetReader != nil:
+		utf8Reader, err := p.charsetReader(label, br)
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("%w %q: %w", ErrUnsupportedEncoding, label, err)
+		}
+		return utf8Reader, label, 0, nil
+	case strings.EqualFold(label, "US-ASCII") || strings.EqualFold(label, "ASCII"):
+		// A subset of UTF-8
+		return br, "", 0, nil
+	case strings.HasPrefix(strings.ToUpper(label), "UTF-16"):
+		return nil, "", 0, fmt.Errorf("%w %q: no UTF-16 byte order mark", ErrUnsupportedEncoding, label)
+	}
+
+	enc, err := ianaindex.IANA.Encoding(label)
+	if err != nil || enc == nil {
+		return nil, "", 0, fmt.Errorf("%w %q", ErrUnsupportedEncoding, label)
+	}
+	return enc.NewDecoder().Reader(br), label, 0, nil
+}
+
+// peekDeclaredEncoding returns the encoding named in the XML declaration at the
+// start of br, or "" if there is none, without consuming any input
+func peekDeclaredEncoding(br *bufio.Reader) (string, error) {
+	head, err := br.Peek(len("<?xml "))
+	if err != nil && err != io.EOF || !bytes.HasPrefix(head, []byte("<?xml")) || len(head) < 6 || !isSpace(head[5]) {
+		return "", ignoreEOF(err)
+	}
+
+	// Peek further until the end of the declaration has been buffered
+	for n := 64; ; n *= 2 {
+		n = min(n, maxDeclarationSize)
+		head, err = br.Peek(n)
+		if end := bytes.Index(head, []byte("?>")); end != -1 {
+			return parseXMLDeclaration(head[len("<?xml"):end]).Encoding, nil
+		}
+		if err != nil || n == maxDeclarationSize {
+			// Unterminated - leave it to the parser to report
+			return "", ignoreEOF(err)
+		}
+	}
+}
+
+// ignoreEOF returns err, or nil if it is io.EOF
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package xmlstreamer
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// maxReferenceLen bounds the length of a reference, including '&' and ';' but not
+// the leading zeros of a character reference, of which there may be any number
+const maxReferenceLen = len("&#x0010FFFF;")
+
+// appendUnescaped appends src to dst, decoding the five predefined XML entities
+// (&lt; &gt; &amp; &apos; &quot;) and decimal/hexadecimal character references.
+// Unknown or malformed references are copied through unchanged.
+func appendUnescaped(dst, src []byte) []byte {
+	for {
+		amp := bytes.IndexByte(src, '&')
+		if amp == -1 {
+			return append(dst, src...)
+		}
+		dst = append(dst, src[:amp]...)
+		src = src[amp:]
+
+		if semi := referenceEnd(src); semi != -1 {
+			if r, ok := decodeReference(src[1:semi]); ok {
+				dst = utf8.AppendRune(dst, r)
+				src = src[semi+1:]
+				continue
+			}
+		}
+
+		// Not a reference we understand - keep the '&' and continue after it
+		dst = append(dst, '&')
+		src = src[1:]
+	}
+}
+
+// referenceEnd returns the index of the ';' that ends the reference at the start of b,
+// which begins with '&', or -1 if there is none. The search is bounded so that stray
+// '&' characters stay linear.
+func referenceEnd(b []byte) int {
+	start := 1
+	if len(b) > 1 && b[1] == '#' {
+		start = 2
+		if len(b) > 2 && b[2] == 'x' {
+			start = 3
+		}
+		for start < len(b) && b[start] == '0' {
+			start++
+		}
+	}
+	semi := bytes.IndexByte(b[start:min(len(b), start+maxReferenceLen)], ';')
+	if semi == -1 {
+		return -1
+	}
+	return start + semi
+}
+
+// unescapeString returns b as a string with references decoded.
+// Bytes without '&' are converted directly without going through the decoder.
+func unescapeString(b []byte) string {
+	if bytes.IndexByte(b, '&') == -1 {
+		return string(b)
+	}
+	return string(appendUnescaped(make([]byte, 0, len(b)), b))
+}
+
+// decodeReference decodes the name between '&' and ';' of an entity or character reference
+func decodeReference(ref []byte) (rune, bool) {
+	switch string(ref) {
+	case "lt":
+		return '<', true
+	case "gt":
+		return '>', true
+	case "amp":
+		return '&', true
+	case "apos":
+		return '\'', true
+	case "quot":
+		return '"', true
+	}
+
+	if len(ref) < 2 || ref[0] != '#' {
+		return 0, false
+	}
+	digits := ref[1:]
+	base := rune(10)
+	if digits[0] == 'x' {
+		base = 16
+		digits = digits[1:]
+		if len(digits) == 0 {
+			return 0, false
+		}
+	}
+
+	var r rune
+	for _, c := range digits {
+		var d rune
+		switch {
+		case c >= '0' && c <= '9':
+			d = rune(c - '0')
+		case base == 16 && c >= 'a' && c <= 'f':
+			d = rune(c-'a') + 10
+		case base == 16 && c >= 'A' && c <= 'F':
+			d = rune(c-'A') + 10
+		default:
+			return 0, false
+		}
+		r = r*base + d
+		if r > utf8.MaxRune {
+			return 0, false
+		}
+	}
+
+	if !isXMLChar(r) {
+		return 0, false
+	}
+	return r, true
+}
+
+// isXMLChar reports whether r matches the Char production of the XML 1.0 specification
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= 0x10FFFF)
+}
@@ -0,0 +1,66 @@
+package xmlstreamer
+
+import "fmt"
+
+// Position identifies a location in the parser input
+type Position struct {
+	Offset int64 // byte offset from the start of the input
+	Line   int   // 1-based line number
+	Column int   // 1-based byte column within the line
+}
+
+// String returns the position formatted as "line L, column C"
+func (pos Position) String() string {
+	return fmt.Sprintf("line %d, column %d", pos.Line, pos.Column)
+}
+
+// ParseError is returned by Parser.Err when parsing stops before the end of the document.
+// Err is either the error returned by the underlying io.Reader or a syntax error;
+// input that ends inside markup or with elements still open is reported as
+// io.ErrUnexpectedEOF.
+// The problems found in recovery mode are passed to the WithRecovery callback as
+// ParseErrors too.
+type ParseError struct {
+	Position
+	Err error
+
+	// Element is the name of the innermost element open when the error occurred, or
+	// of the element whose start tag it is in, starting at ElementPosition. It is ""
+	// if the error is outside the document element.
+	Element         string
+	ElementPosition Position
+
+	// Path is the path of that element from the document element, e.g. "/rss/channel/item"
+	Path string
+}
+
+func (e *ParseError) Error() string {
+	if e.Element == "" {
+		return fmt.Sprintf("xmlstreamer: %s (offset %d): %v", e.Position, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("xmlstreamer: %s (offset %d): %v (in <%s> at %s)", e.Position, e.Offset, e.Err, e.Element, e.ElementPosition)
+}
+
+// Unwrap returns the underlying error so errors.Is and errors.As can inspect it
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is returned by XMLElement.Decode when a value cannot be stored in a field
+type DecodeError struct {
+	Position        // position of the element or node holding the value
+	Field    string // path of the struct field, e.g. "Offer.Price"; "" for the value passed to Decode
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("xmlstreamer: decoding element at %s: %v", e.Position, e.Err)
+	}
+	return fmt.Sprintf("xmlstreamer: decoding field %s at %s: %v", e.Field, e.Position, e.Err)
+}
+
+// Unwrap returns the underlying conversion error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,414 @@
+package xmlstreamer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Filter is a compiled predicate over streamed elements, written in a subset of
+// XPath predicate syntax:
+//
+//	@status                  the attribute exists
+//	@status='active'         the attribute has the value ('!=' for the opposite)
+//	category                 a child element named category exists
+//	category='books'         some child element category has the text value
+//	.='text'                 the element's own text value
+//	not(...), and, or, (...) boolean combinators
+//
+// Names may be prefixed (g:id) or use Clark notation ({URI}id). Attribute tests
+// are decided when the start tag is read, so elements that fail them are never
+// built; tests on children and text are decided when the end tag is read.
+type Filter struct {
+	expr string
+	root *predicate
+}
+
+// CompileFilter compiles a predicate expression for use with WithFilter
+func CompileFilter(expr string) (*Filter, error) {
+	pred, err := parsePredicate(expr)
+	if err != nil {
+		return nil, fmt.Errorf("xmlstreamer: invalid filter %q: %w", expr, err)
+	}
+	return &Filter{expr: expr, root: pred}, nil
+}
+
+// String returns the source expression of the filter
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// tri is the result of evaluating a predicate that may depend on content not yet parsed
+type tri uint8
+
+const (
+	triFalse tri = iota
+	triTrue
+	triUnknown // depends on children or text that have not been read yet
+)
+
+func (t tri) not() tri {
+	switch t {
+	case triTrue:
+		return triFalse
+	case triFalse:
+		return triTrue
+	}
+	return triUnknown
+}
+
+type predicateOp uint8
+
+const (
+	predAnd predicateOp = iota
+	predOr
+	predNot
+	predExists    // operand is present
+	predEquals    // operand is present and equal to value
+	predNotEquals // operand is present and not equal to value
+)
+
+type operandKind uint8
+
+const (
+	operandAttribute operandKind = iota
+	operandChild
+	operandSelf
+)
+
+// predicate is a node of a compiled filter expression
+type predicate struct {
+	op          predicateOp
+	left, right *predicate // operands of and/or, left is the operand of not
+
+	kind  operandKind
+	name  selectorStep // name test for attribute and child operands
+	value string
+}
+
+// and combines two predicates, either of which may be nil
+func (pred *predicate) and(other *predicate) *predicate {
+	if pred == nil {
+		return other
+	}
+	if other == nil {
+		return pred
+	}
+	return &predicate{op: predAnd, left: pred, right: other}
+}
+
+// bindNamespaces returns a copy of the predicate whose names resolve prefixes
+// bound in namespaces (see selectorStep.bindNamespaces). Compiled filters may be
+// shared between parsers, so the original is left untouched.
+func (pred *predicate) bindNamespaces(namespaces map[string]string) *predicate {
+	if pred == nil || len(namespaces) == 0 {
+		return pred
+	}
+	bound := *pred
+	bound.left = pred.left.bindNamespaces(namespaces)
+	bound.right = pred.right.bindNamespaces(namespaces)
+	bound.name = pred.name.bindNamespaces(namespaces)
+	return &bound
+}
+
+// needsContent reports whether the predicate depends on children or text
+func (pred *predicate) needsContent() bool {
+	switch pred.op {
+	case predAnd, predOr:
+		return pred.left.needsContent() || pred.right.needsContent()
+	case predNot:
+		return pred.left.needsContent()
+	}
+	return pred.kind != operandAttribute
+}
+
+// eval evaluates the predicate on elem. Before the element is closed, tests on
+// its content evaluate to triUnknown; once closed the result is never triUnknown.
+func (pred *predicate) eval(elem *XMLElement, closed bool) tri {
+	switch pred.op {
+	case predAnd:
+		l := pred.left.eval(elem, closed)
+		if l == triFalse {
+			return triFalse
+		}
+		r := pred.right.eval(elem, closed)
+		if r == triFalse {
+			return triFalse
+		}
+		if l == triTrue && r == triTrue {
+			return triTrue
+		}
+		return triUnknown
+	case predOr:
+		l := pred.left.eval(elem, closed)
+		if l == triTrue {
+			return triTrue
+		}
+		r := pred.right.eval(elem, closed)
+		if r == triTrue {
+			return triTrue
+		}
+		if l == triFalse && r == triFalse {
+			return triFalse
+		}
+		return triUnknown
+	case predNot:
+		return pred.left.eval(elem, closed).not()
+	}
+
+	switch pred.kind {
+	case operandAttribute:
+		for i := range elem.Attributes {
+			attr := &elem.Attributes[i]
+			if pred.matchesAttributeName(attr) && pred.test(attr.Value) {
+				return triTrue
+			}
+		}
+		return triFalse
+	case operandChild:
+		if !closed {
+			return triUnknown
+		}
+		for _, child := range elem.children {
+			if c, ok := child.(*XMLElement); ok && pred.name.matchesName(c) && pred.test(c.InnerText()) {
+				return triTrue
+			}
+		}
+		return triFalse
+	default: // operandSelf
+		if !closed {
+			return triUnknown
+		}
+		if pred.test(elem.InnerText()) {
+			return triTrue
+		}
+		return triFalse
+	}
+}
+
+// test applies the comparison of a leaf predicate to the value of one operand
+func (pred *predicate) test(value string) bool {
+	switch pred.op {
+	case predEquals:
+		return value == pred.value
+	case predNotEquals:
+		return value != pred.value
+	}
+	return true
+}
+
+// matchesAttributeName compares an attribute's name with the predicate's name test
+func (pred *predicate) matchesAttributeName(attr *XMLAttribute) bool {
+	if !pred.name.clark {
+		return pred.name.name == attr.Name
+	}
+	return pred.name.namespaceURI == attr.NamespaceURI && pred.name.localName == attr.LocalName
+}
+
+// predicateParser is a recursive descent parser for filter expressions:
+//
+//	or     := and ('or' and)*
+//	and    := unary ('and' unary)*
+//	unary  := 'not' '(' or ')' | '(' or ')' | test
+//	test   := operand (('=' | '!=') literal)?
+//	operand := '@' name | name | '.'
+type predicateParser struct {
+	s   string
+	pos int
+}
+
+// parsePredicate parses a complete filter expression
+func parsePredicate(expr string) (*predicate, error) {
+	p := &predicateParser{s: expr}
+	pred, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.s[p.pos:], p.pos)
+	}
+	return pred, nil
+}
+
+func (p *predicateParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\n' || p.s[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// keyword consumes kw if it appears next as a whole word
+func (p *predicateParser) keyword(kw string) bool {
+	p.skipSpace()
+	if !strings.HasPrefix(p.s[p.pos:], kw) {
+		return false
+	}
+	if end := p.pos + len(kw); end < len(p.s) && isNameByte(p.s[end]) {
+		return false
+	}
+	p.pos += len(kw)
+	return true
+}
+
+// consume consumes the byte c if it appears next
+func (p *predicateParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *predicateParser) parseOr() (*predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &predicate{op: predOr, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *predicateParser) parseAnd() (*predicate, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &predicate{op: predAnd, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *predicateParser) parseUnary() (*predicate, error) {
+	start := p.pos
+	if p.keyword("not") {
+		if !p.consume('(') {
+			// An element named "not"
+			p.pos = start
+			return p.parseTest()
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(')') {
+			return nil, errors.New("missing ')' after not(")
+		}
+		return &predicate{op: predNot, left: inner}, nil
+	}
+	if p.consume('(') {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(')') {
+			return nil, errors.New("missing ')'")
+		}
+		return inner, nil
+	}
+	return p.parseTest()
+}
+
+func (p *predicateParser) parseTest() (*predicate, error) {
+	pred := &predicate{op: predExists}
+
+	p.skipSpace()
+	switch {
+	case p.consume('@'):
+		pred.kind = operandAttribute
+	case p.consume('.'):
+		pred.kind = operandSelf
+	default:
+		pred.kind = operandChild
+	}
+
+	if pred.kind != operandSelf {
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		pred.name, err = parseSelectorStep(name)
+		if err != nil {
+			return nil, err
+		}
+		if pred.name.name == "*" || pred.name.localName == "*" {
+			return nil, fmt.Errorf("wildcard %q is not supported in filters", name)
+		}
+	}
+
+	switch {
+	case p.consume('='):
+		pred.op = predEquals
+	case p.consume('!'):
+		if !p.consume('=') {
+			return nil, fmt.Errorf("expected '=' after '!' at offset %d", p.pos)
+		}
+		pred.op = predNotEquals
+	default:
+		if pred.kind == operandSelf {
+			return nil, errors.New("'.' must be compared with a literal")
+		}
+		return pred, nil
+	}
+
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	pred.value = value
+	return pred, nil
+}
+
+// parseName reads a qualified name or a {URI}localName
+func (p *predicateParser) parseName() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	if p.pos < len(p.s) && p.s[p.pos] == '{' {
+		end := strings.IndexByte(p.s[p.pos:], '}')
+		if end == -1 {
+			return "", errors.New("unterminated namespace URI")
+		}
+		p.pos += end + 1
+	}
+	for p.pos < len(p.s) && isNameByte(p.s[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", fmt.Errorf("expected name at offset %d", p.pos)
+	}
+	return p.s[start:p.pos], nil
+}
+
+// parseLiteral reads a single or double quoted string
+func (p *predicateParser) parseLiteral() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) || (p.s[p.pos] != '\'' && p.s[p.pos] != '"') {
+		return "", fmt.Errorf("expected quoted literal at offset %d", p.pos)
+	}
+	quote := p.s[p.pos]
+	end := strings.IndexByte(p.s[p.pos+1:], quote)
+	if end == -1 {
+		return "", errors.New("unterminated literal")
+	}
+	value := p.s[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return value, nil
+}
+
+// isNameByte reports whether c can appear in an element or attribute name.
+// Bytes of multi-byte UTF-8 sequences are accepted as name characters.
+func isNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.' || c == ':' || c == '*' || c >= 0x80
+}
@@ -3,6 +3,9 @@ module github.com/wilkmaciej/xml-streamer
 go 1.26
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/orisano/gosax v1.1.4
+	github.com/ulikunitz/xz v0.5.15
 	github.com/wilkmaciej/xpath v1.3.7
+	golang.org/x/text v0.40.0
 )
@@ -35,16 +35,16 @@ func (navigator *elementNavigator) NodeType() xpath.NodeType {
 // LocalName returns the local name of the current node
 func (navigator *elementNavigator) LocalName() string {
 	if navigator.attributeIndex != -1 {
-		name := navigator.currElement.Attributes[navigator.attributeIndex].Name
-		if idx := strings.IndexByte(name, ':'); idx != -1 {
-			return name[idx+1:]
-		}
-		return name
+		return navigator.currElement.Attributes[navigator.attributeIndex].LocalName
 	}
 	if navigator.currElement != nil {
 		return navigator.currElement.localName
 	}
-	// Text and comment nodes have no local name
+	// A processing instruction is named by its target; text and comment nodes
+	// have no local name
+	if node, ok := navigator.currNode.(*XMLContentNode); ok {
+		return node.target
+	}
 	return ""
 }
 
@@ -68,15 +68,7 @@ func (navigator *elementNavigator) Prefix() string {
 // URL should be URI but kept for compatibility
 func (navigator *elementNavigator) NamespaceURL() string {
 	if navigator.attributeIndex != -1 {
-		// For attributes, check if they have a namespace prefix
-		attrName := navigator.currElement.Attributes[navigator.attributeIndex].Name
-		if idx := strings.IndexByte(attrName, ':'); idx != -1 {
-			prefix := attrName[:idx]
-			if navigator.currElement.namespaces != nil {
-				return navigator.currElement.namespaces[prefix]
-			}
-		}
-		return ""
+		return navigator.currElement.Attributes[navigator.attributeIndex].NamespaceURI
 	}
 	if navigator.currElement != nil {
 		return navigator.currElement.namespaceURI
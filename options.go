@@ -0,0 +1,136 @@
+package xmlstreamer
+
+import "maps"
+
+// Option configures optional Parser behaviour
+type Option func(*Parser)
+
+// WithStreamSelectors adds selectors of the elements to stream.
+// See NewParser for the selector syntax.
+func WithStreamSelectors(selectors ...string) Option {
+	return func(p *Parser) {
+		p.streamNames = append(p.streamNames, selectors...)
+	}
+}
+
+// WithChannelSize sets the buffer size of the channel returned by Stream.
+// Values <= 0 select the default of 8.
+func WithChannelSize(size int) Option {
+	return func(p *Parser) {
+		if size <= 0 {
+			size = defaultChannelSize
+		}
+		p.bufferSize = size
+	}
+}
+
+// WithReadBufferSize sets the initial size of the input buffer, 64 KiB by default.
+// The buffer grows on demand when a single token does not fit, so a small value
+// only costs extra reads; large values mainly reduce the number of Read calls.
+// Values <= 0 select the default.
+func WithReadBufferSize(size int) Option {
+	return func(p *Parser) {
+		if size <= 0 {
+			size = defaultReadBufferSize
+		}
+		p.readBufferSize = size
+	}
+}
+
+// WithFilter restricts streaming to elements that pass f in addition to a stream selector.
+// It may be given several times; an element must pass every filter.
+func WithFilter(f *Filter) Option {
+	return func(p *Parser) {
+		p.filters = append(p.filters, f)
+	}
+}
+
+// WithNamespaces binds namespace prefixes to URIs for the parser's stream selectors,
+// filters and CompileXPath, so that a prefixed name such as g:item matches elements
+// in the bound namespace whatever prefix the document uses for it. It may be given
+// several times; later bindings of a prefix win. Unbound prefixes in selectors and
+// filters keep matching the prefix literally, except in a prefix:* step, which is an
+// error. CompileXPath differs: once there are bindings, every prefix in the
+// expression must be bound, and an unbound one is a compile error.
+func WithNamespaces(namespaces map[string]string) Option {
+	return func(p *Parser) {
+		if p.namespaces == nil {
+			p.namespaces = make(map[string]string, len(namespaces))
+		}
+		maps.Copy(p.namespaces, namespaces)
+	}
+}
+
+// WithRawCapture makes the parser keep the exact source bytes of every streamed
+// element, available from XMLElement.Raw. This copies the input of streamed
+// subtrees once more, so only enable it when the original bytes are needed.
+// Input that has to be converted to UTF-8 first, such as UTF-16 or ISO-8859-2, has
+// no such bytes: parsing it stops with a *ParseError wrapping ErrUnsupportedEncoding.
+func WithRawCapture() Option {
+	return func(p *Parser) {
+		p.captureRaw = true
+	}
+}
+
+// WithCharsetReader sets the function that converts input in an encoding other than
+// UTF-8 to UTF-8, like encoding/xml.Decoder.CharsetReader. It is called with the
+// encoding declared in the XML declaration and replaces the built-in conversion of
+// the encodings registered with IANA, e.g. ISO-8859-2 or windows-1250. UTF-16 input
+// with a byte order mark is always converted by the parser itself.
+func WithCharsetReader(charsetReader CharsetReader) Option {
+	return func(p *Parser) {
+		p.charsetReader = charsetReader
+	}
+}
+
+// WithAutoDecompress makes the parser detect input compressed with gzip, zstd, bzip2
+// or xz from its first bytes and decompress it while parsing. Concatenated gzip
+// members, zstd frames and xz streams are read as one document. Uncompressed input
+// is parsed as is.
+func WithAutoDecompress() Option {
+	return func(p *Parser) {
+		p.autoDecompress = true
+	}
+}
+
+// WithStrict makes the parser check that the document is well-formed, which it
+// otherwise only does as far as needed to parse it. Parsing stops at the first
+// violation with a *ParseError wrapping ErrNotWellFormed: an end tag that does not
+// match its start tag, more than one root element or text outside it, an invalid
+// element or attribute name, a repeated attribute, an undeclared namespace prefix,
+// an '&' that does not start a reference, or elements still open at the end of the input.
+func WithStrict() Option {
+	return func(p *Parser) {
+		p.strict = true
+	}
+}
+
+// WithRecovery makes the parser keep going past the problems that WithStrict reports,
+// passing each of them to report instead and repairing what it can:
+//   - an end tag that does not match closes the open elements up to the one it names,
+//     or is ignored if no open element has its name
+//   - a stray '&' is kept as text
+//   - a start tag that is not a valid tag is ignored, and the streamed element around
+//     it is dropped and reported with ErrSkippedRecord; a start tag with that
+//     element's name closes it, as the broken tag may have swallowed its end tag
+//   - at the end of the input, streamed elements still open are dropped and reported
+//     with ErrSkippedRecord, and Err reports nothing
+//
+// Other problems are reported and left as they are. Each *ParseError passed to
+// report carries the position and the path of the element concerned. Read errors,
+// and syntax errors the tokenizer cannot get past, still stop parsing.
+func WithRecovery(report func(*ParseError)) Option {
+	return func(p *Parser) {
+		p.strict = true
+		p.report = report
+	}
+}
+
+// WithUnquotedAttributes makes the parser accept attribute values without quotes,
+// as in <item id=42>, which end at the next whitespace. Without it such attributes
+// are left out of the element, and reported in strict and recovery mode.
+func WithUnquotedAttributes() Option {
+	return func(p *Parser) {
+		p.unquotedAttributes = true
+	}
+}
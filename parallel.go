@@ -0,0 +1,140 @@
+package xmlstreamer
+
+import (
+	"iter"
+	"runtime"
+	"sync"
+)
+
+// ProcessParallel streams elements from p and processes them using workers
+// goroutines (pass 0 to use GOMAXPROCS). Each worker calls newWorker once to obtain
+// its processing function fn. A compiled *xpath.Expr keeps evaluation state and is
+// not safe for concurrent use, so newWorker is the place to compile the expressions
+// fn evaluates. Each element is released back to the pool after fn returns, so fn
+// must not retain the element, its children or any string obtained from them
+// without copying.
+//
+// The returned iterator yields the result of every call to fn. With ordered set,
+// results are yielded in document order; otherwise in completion order. Errors
+// returned by fn are yielded with the value fn returned and processing continues;
+// a parser error (see Parser.Err) is yielded last. Breaking out of the loop stops
+// the parser and waits for the workers to exit.
+//
+// ProcessParallel consumes the parser's Stream.
+func ProcessParallel[T any](p *Parser, workers int, ordered bool, newWorker func() func(*XMLElement) (T, error)) iter.Seq2[T, error] {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	type job struct {
+		seq  int
+		elem *XMLElement
+	}
+	type result struct {
+		seq   int
+		value T
+		err   error
+	}
+
+	return func(yield func(T, error) bool) {
+		// Limit the number of elements between the parser and the consumer. This
+		// bounds memory when one slow element holds back ordered output, and lets
+		// every channel below be buffered so that no send ever blocks.
+		maxInFlight := workers * 4
+		inFlight := make(chan struct{}, maxInFlight)
+		jobs := make(chan job, maxInFlight)
+		results := make(chan result, maxInFlight)
+		stop := make(chan struct{})
+
+		// Dispatcher: numbers elements in document order and hands them to workers
+		go func() {
+			defer close(jobs)
+			seq := 0
+			for elem := range p.Stream() {
+				select {
+				case inFlight <- struct{}{}:
+				case <-stop:
+					elem.Release()
+					return
+				}
+				jobs <- job{seq: seq, elem: elem}
+				seq++
+			}
+		}()
+
+		var wg sync.WaitGroup
+		wg.Add(workers)
+		for range workers {
+			go func() {
+				defer wg.Done()
+				fn := newWorker()
+				for j := range jobs {
+					select {
+					case <-stop:
+						// Consumer is gone - skip the work
+						j.elem.Release()
+						continue
+					default:
+					}
+					value, err := fn(j.elem)
+					j.elem.Release()
+					results <- result{seq: j.seq, value: value, err: err}
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		stopped := false
+		emit := func(r result) bool {
+			<-inFlight
+			if !yield(r.value, r.err) {
+				stopped = true
+				return false
+			}
+			return true
+		}
+
+		pending := make(map[int]result)
+		next := 0
+		for r := range results {
+			if !ordered {
+				if !emit(r) {
+					break
+				}
+				continue
+			}
+			pending[r.seq] = r
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if !emit(r) {
+					break
+				}
+			}
+			if stopped {
+				break
+			}
+		}
+
+		if stopped {
+			close(stop)
+			_ = p.Close()
+			// Let the workers finish so none of them outlives the iterator
+			for range results {
+			}
+			return
+		}
+
+		if err := p.Err(); err != nil {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
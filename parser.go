@@ -3,77 +3,381 @@ package xmlstreamer
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"iter"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/orisano/gosax"
 	"github.com/wilkmaciej/xpath"
 )
 
+// errConsumed is yielded by All when the parser has already been consumed
+var errConsumed = errors.New("xmlstreamer: parser already consumed by Stream or All")
+
+// Default values for the settings configured by options
+const (
+	defaultChannelSize    = 8
+	defaultReadBufferSize = 64 * 1024 // gosax grows the buffer when a single token needs more
+)
+
 // Parser provides streaming XML parsing with XPath support.
 type Parser struct {
-	ctx         context.Context
-	reader      io.Reader
-	streamNames map[string]bool // Optional: specific element names to stream
-	bufferSize  int
-	once        sync.Once
-	ch          chan *XMLElement
+	ctx                context.Context
+	cancel             context.CancelFunc
+	reader             io.Reader
+	streamNames        []string          // Selector expressions collected from options
+	selectors          *selectorSet      // Optional: selectors of the elements to stream
+	selectorErr        error             // Set if a selector failed to compile, reported by Err
+	filters            []*Filter         // Predicates every streamed element must pass
+	namespaces         map[string]string // Prefix bindings for selectors, filters and CompileXPath
+	bufferSize         int               // Channel buffer size
+	readBufferSize     int               // Initial size of the gosax read buffer
+	captureRaw         bool              // Keep the source bytes of streamed elements
+	charsetReader      CharsetReader     // Optional: converts declared encodings to UTF-8
+	autoDecompress     bool              // Detect and decompress compressed input
+	strict             bool              // Check well-formedness
+	report             func(*ParseError) // Optional: recovery mode, receives the violations instead of Err
+	unquotedAttributes bool              // Accept attribute values without quotes
+	inputCloser        io.Closer         // Optional: closed once parsing ends, set by NewParserFromFile
+	closeOnce          sync.Once
+	declaration        atomic.Pointer[XMLDeclaration] // Set once the XML declaration has been read
+	docType            atomic.Pointer[DocType]        // Set once the DOCTYPE has been read
+	once               sync.Once
+	ch                 chan *XMLElement
+	done               chan struct{} // closed when the parse goroutine has exited
+	err                error
 }
 
 // NewParser creates a new XML parser
-// streamNames: specific element names to stream (pass nil or empty slice to stream nothing)
+// streamNames: selectors of the elements to stream (pass nil or empty slice to stream nothing).
+// A selector is an element name such as "item", or a path such as "channel/item",
+// "/rss/channel/item" or "//offers/offer"; an invalid selector is reported by Err.
 // bufferSize: channel buffer size for streaming (pass 0 to use default of 8)
-func NewParser(ctx context.Context, reader io.Reader, streamNames []string, bufferSize int) *Parser {
-	if bufferSize <= 0 {
-		bufferSize = 8
-	}
+// opts: further options, applied after streamNames and bufferSize
+//
+// NewParser is a shorthand for NewParserWithOptions with WithStreamSelectors and WithChannelSize.
+func NewParser(ctx context.Context, reader io.Reader, streamNames []string, bufferSize int, opts ...Option) *Parser {
+	return NewParserWithOptions(ctx, reader, append([]Option{WithStreamSelectors(streamNames...), WithChannelSize(bufferSize)}, opts...)...)
+}
 
+// NewParserWithOptions creates a new XML parser configured by opts.
+// Without WithStreamSelectors the parser reads the whole input but streams nothing.
+func NewParserWithOptions(ctx context.Context, reader io.Reader, opts ...Option) *Parser {
+	ctx, cancel := context.WithCancel(ctx)
 	p := &Parser{
-		ctx:        ctx,
-		reader:     reader,
-		bufferSize: bufferSize,
+		ctx:            ctx,
+		cancel:         cancel,
+		reader:         &contextReader{ctx: ctx, r: reader},
+		bufferSize:     defaultChannelSize,
+		readBufferSize: defaultReadBufferSize,
+		done:           make(chan struct{}),
 	}
 
-	if len(streamNames) > 0 {
-		p.streamNames = make(map[string]bool)
-		for _, name := range streamNames {
-			p.streamNames[name] = true
-		}
+	for _, opt := range opts {
+		opt(p)
 	}
 
+	p.selectors, p.selectorErr = newSelectorSet(p.streamNames, p.filters, p.namespaces)
+
 	return p
 }
 
+// CompileXPath compiles an XPath expression in which the prefixes bound with
+// WithNamespaces match by namespace URI. Unlike in selectors and filters, other
+// prefixes are not matched literally: they make compilation fail. Without bindings
+// it is xpath.Compile, and all prefixes match literally.
+func (p *Parser) CompileXPath(expr string) (*xpath.Expr, error) {
+	if len(p.namespaces) == 0 {
+		return xpath.Compile(expr)
+	}
+	return xpath.CompileWithNS(expr, p.namespaces)
+}
+
 // Stream returns a channel of XMLElements as they are parsed.
 // It is safe to call multiple times — subsequent calls return the same channel.
 func (p *Parser) Stream() <-chan *XMLElement {
 	p.once.Do(func() {
 		p.ch = make(chan *XMLElement, p.bufferSize)
 		go func() {
+			defer close(p.done)
 			defer close(p.ch)
-			p.parse(p.ch)
+			defer p.cancel() // Detach the internal context from its parent once parsing is over
+			p.err = p.parse(p.send)
 		}()
 	})
 	return p.ch
 }
 
+// send delivers elem to the Stream channel, giving up if the context is cancelled
+func (p *Parser) send(elem *XMLElement) bool {
+	select {
+	case p.ch <- elem:
+		return true
+	case <-p.ctx.Done():
+		// Consumer is gone - nobody else will release this element
+		returnElementToPool(elem)
+		return false
+	}
+}
+
+// All returns an iterator over the streamed elements that parses synchronously in
+// the caller's goroutine, without the channel used by Stream. If parsing fails,
+// the last pair yielded carries a nil element and the error (see Err).
+// Breaking out of the loop stops parsing; Err then reports context.Canceled.
+// A parser can be consumed only once, either with All or with Stream.
+func (p *Parser) All() iter.Seq2[*XMLElement, error] {
+	return func(yield func(*XMLElement, error) bool) {
+		claimed := false
+		p.once.Do(func() {
+			claimed = true
+			// Leave a closed channel behind for Stream and Close
+			p.ch = make(chan *XMLElement)
+			close(p.ch)
+		})
+		if !claimed {
+			yield(nil, errConsumed)
+			return
+		}
+		defer close(p.done)
+		defer p.cancel()
+
+		stopped := false
+		p.err = p.parse(func(elem *XMLElement) bool {
+			if !yield(elem, nil) {
+				stopped = true
+				p.cancel()
+				return false
+			}
+			return true
+		})
+		if p.err != nil && !stopped {
+			yield(nil, p.err)
+		}
+	}
+}
+
+// Close stops parsing and blocks until the background goroutine has exited.
+// Elements still buffered in the channel are released back to the pool.
+// It is safe to call Close while another goroutine ranges over Stream or All and
+// to call it more than once, but not from inside a loop over All - use break
+// there instead. The underlying io.Reader is not closed.
+func (p *Parser) Close() error {
+	p.cancel()
+	p.once.Do(func() {
+		// Stream was never called - leave a closed channel behind for later callers
+		p.ch = make(chan *XMLElement)
+		close(p.ch)
+		close(p.done)
+	})
+	for elem := range p.ch {
+		elem.Release()
+	}
+	<-p.done
+	p.closeInput()
+	return nil
+}
+
+// Err returns the error that stopped parsing, or nil if the whole document was read.
+// Read and syntax errors are reported as *ParseError; if the context was cancelled
+// or Close was called, the context's error is returned. Err must only be called
+// after the channel returned by Stream has been closed or the loop over All has ended.
+func (p *Parser) Err() error {
+	return p.err
+}
+
 type parseState struct {
 	stack []*XMLElement
 	depth int
+
+	// Number of open elements that will be streamed. Elements, text and comments
+	// are only attached to the tree while this is non-zero; everything outside a
+	// streamed subtree is discarded as soon as it is closed.
+	streamDepth int
+
+	// Set once the root element has been opened, for the single root check of strict mode
+	rootSeen bool
+
+	// In recovery mode, the streamed elements among the first brokenDepth elements
+	// of stack contain an unparseable tag and are dropped when they are closed
+	brokenDepth int
+
+	// Input position of the next event, used for error reporting
+	offset    int64
+	line      int
+	lineStart int64 // offset of the first byte of the current line
+
+	// Source bytes of the outermost open streamed element so far, kept with
+	// WithRawCapture. raw[0] is the byte at input offset rawOffset.
+	raw       []byte
+	rawOffset int64
+}
+
+// advance moves the tracked input position past the bytes of one event
+func (state *parseState) advance(b []byte) {
+	if n := bytes.Count(b, []byte{'\n'}); n > 0 {
+		state.line += n
+		state.lineStart = state.offset + int64(bytes.LastIndexByte(b, '\n')) + 1
+	}
+	state.offset += int64(len(b))
+}
+
+// release returns the elements still open on the stack to the pool.
+// Elements inside a streamed subtree are released together with their subtree root.
+func (state *parseState) release() {
+	// Innermost first: releasing a subtree root clears the parent of its descendants
+	for _, elem := range slices.Backward(state.stack) {
+		if elem.parent == nil {
+			returnElementToPool(elem)
+		}
+	}
+	state.stack = state.stack[:0]
+}
+
+// position returns the current input position
+func (state *parseState) position() Position {
+	return Position{
+		Offset: state.offset,
+		Line:   state.line,
+		Column: int(state.offset-state.lineStart) + 1,
+	}
+}
+
+// positionAt returns the input position of b[i], where b are the bytes of the
+// current event
+func (state *parseState) positionAt(b []byte, i int) Position {
+	pos := state.position()
+	pos.Offset += int64(i)
+	if n := bytes.Count(b[:i], []byte{'\n'}); n > 0 {
+		pos.Line += n
+		pos.Column = i - bytes.LastIndexByte(b[:i], '\n')
+	} else {
+		pos.Column += i
+	}
+	return pos
+}
+
+// parseError wraps err with the current position and the innermost open element
+func (state *parseState) parseError(err error) *ParseError {
+	return state.parseErrorAt(state.position(), err)
+}
+
+// parseErrorAt wraps err with pos and the innermost open element
+func (state *parseState) parseErrorAt(pos Position, err error) *ParseError {
+	parseErr := &ParseError{Position: pos, Err: err}
+	if len(state.stack) > 0 {
+		elem := state.stack[len(state.stack)-1]
+		parseErr.Element = elem.Name
+		parseErr.ElementPosition = elem.position
+		parseErr.Path = elementPath(state.stack)
+	}
+	return parseErr
 }
 
-func (p *Parser) parse(ch chan<- *XMLElement) {
+// startTagError wraps err, found at pos in the start tag of elem, with elem as the
+// innermost element; elem has not been pushed yet
+func (state *parseState) startTagError(elem *XMLElement, pos Position, err error) *ParseError {
+	return &ParseError{
+		Position:        pos,
+		Err:             err,
+		Element:         elem.Name,
+		ElementPosition: elem.position,
+		Path:            elementPath(append(state.stack[:len(state.stack):len(state.stack)], elem)),
+	}
+}
+
+// elementPath returns the path of the last element of stack, e.g. "/rss/channel/item"
+func elementPath(stack []*XMLElement) string {
+	var sb strings.Builder
+	for _, elem := range stack {
+		sb.WriteByte('/')
+		sb.WriteString(elem.Name)
+	}
+	return sb.String()
+}
+
+// emitFunc delivers a streamed element to the consumer.
+// It returns false if parsing should stop; the element has then been disposed of.
+type emitFunc func(elem *XMLElement) bool
+
+func (p *Parser) parse(emit emitFunc) error {
 	state := &parseState{
 		stack: make([]*XMLElement, 0, 32),
+		line:  1,
 	}
+	defer state.release()
+	defer p.closeInput()
 
-	r := gosax.NewReaderSize(p.reader, 1024*1024*64)
+	if p.selectorErr != nil {
+		return p.selectorErr
+	}
+
+	input := p.reader
+	var err error
+	if p.autoDecompress {
+		var release func()
+		if input, release, err = decompressInput(input); err == nil {
+			defer release()
+		}
+	}
+	if err == nil {
+		var converted string
+		var bomLen int
+		if input, converted, bomLen, err = p.decodeInput(input); err == nil && converted != "" && p.captureRaw {
+			err = errRawCaptureEncoding(converted)
+		}
+		// Positions count the byte order mark, but columns start after it
+		state.offset = int64(bomLen)
+		state.lineStart = state.offset
+	}
+	if err != nil {
+		if ctxErr := p.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return state.parseError(err)
+	}
+	r := gosax.NewReaderSize(input, p.readBufferSize)
 
 	for {
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+
 		e, err := r.Event()
-		if err != nil || e.Type() == gosax.EventEOF || p.ctx.Err() != nil {
-			break
+		if err != nil {
+			// contextReader fails reads once the context is cancelled
+			if ctxErr := p.ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			// gosax reports io.EOF when the input ends inside markup
+			if err == io.EOF {
+				return p.endOfInput(state, io.ErrUnexpectedEOF)
+			}
+			return state.parseError(err)
+		}
+		if e.Type() == gosax.EventEOF {
+			// Input ending with elements still open has been cut short
+			if len(state.stack) > 0 {
+				return p.endOfInput(state, io.ErrUnexpectedEOF)
+			}
+			if p.strict && !state.rootSeen {
+				return p.endOfInput(state, errNoRootElement)
+			}
+			return nil
+		}
+
+		// Event bytes are only valid until the next call to Event, so keep a copy
+		// of everything from the start tag of a streamed element to its end tag.
+		// Start tags are copied before it is known whether they will be streamed.
+		if p.captureRaw && (state.streamDepth > 0 || e.Type() == gosax.EventStart) {
+			if len(state.raw) == 0 {
+				state.rawOffset = state.offset
+			}
+			state.raw = append(state.raw, e.Bytes...)
 		}
 
 		switch e.Type() {
@@ -84,27 +388,44 @@ func (p *Parser) parse(ch chan<- *XMLElement) {
 			if len(attrs) > 0 && bytes.Contains(attrs, []byte("xmlns")) {
 				elementNamespaces = p.extractNamespaces(attrs)
 			}
-			p.handleStartElement(state, ch, name, attrs, e.Bytes, elementNamespaces)
+			if err := p.handleStartElement(state, emit, name, attrs, e.Bytes, elementNamespaces); err != nil {
+				return err
+			}
 
 		case gosax.EventEnd:
-			p.handleEndElement(state, ch)
+			if err := p.handleEndElement(state, emit, e.Bytes); err != nil {
+				return err
+			}
 
 		case gosax.EventText:
-			if len(state.stack) > 0 && len(e.Bytes) > 0 {
+			if p.strict {
+				if err := p.checkText(state, e.Bytes); err != nil {
+					return err
+				}
+			}
+			if state.streamDepth > 0 && len(e.Bytes) > 0 {
 				parent := state.stack[len(state.stack)-1]
 				node := getContentNodeFromPool()
-				// Store offsets into parent's rawContent buffer
+				// Store offsets into parent's rawContent buffer, decoding entity references
 				node.start = len(parent.rawContent)
-				parent.rawContent = append(parent.rawContent, e.Bytes...)
+				parent.rawContent = appendUnescaped(parent.rawContent, e.Bytes)
 				node.end = len(parent.rawContent)
 				node.nodeType = xpath.TextNode
+				node.target = ""
+				node.cdata = false
+				node.position = state.position()
 				node.parent = parent
 				node.siblingIndex = len(parent.children)
 				parent.children = append(parent.children, node)
 			}
 
 		case gosax.EventCData:
-			if len(state.stack) > 0 {
+			if p.strict && len(state.stack) == 0 {
+				if err := p.violation(state.parseError(errTextOutsideRoot)); err != nil {
+					return err
+				}
+			}
+			if state.streamDepth > 0 {
 				// Strip <![CDATA[ prefix and ]]> suffix
 				content := e.Bytes
 				if len(content) > 12 { // len("<![CDATA[]]>") = 12
@@ -117,6 +438,9 @@ func (p *Parser) parse(ch chan<- *XMLElement) {
 						parent.rawContent = append(parent.rawContent, content...)
 						node.end = len(parent.rawContent)
 						node.nodeType = xpath.TextNode
+						node.target = ""
+						node.cdata = true
+						node.position = state.position()
 						node.parent = parent
 						node.siblingIndex = len(parent.children)
 						parent.children = append(parent.children, node)
@@ -125,7 +449,7 @@ func (p *Parser) parse(ch chan<- *XMLElement) {
 			}
 
 		case gosax.EventComment:
-			if len(state.stack) > 0 {
+			if state.streamDepth > 0 {
 				// Strip <!-- prefix and --> suffix
 				content := e.Bytes
 				if len(content) > 7 { // len("<!---->") = 7
@@ -137,18 +461,71 @@ func (p *Parser) parse(ch chan<- *XMLElement) {
 					parent.rawContent = append(parent.rawContent, content...)
 					node.end = len(parent.rawContent)
 					node.nodeType = xpath.CommentNode
+					node.target = ""
+					node.cdata = false
+					node.position = state.position()
 					node.parent = parent
 					node.siblingIndex = len(parent.children)
 					parent.children = append(parent.children, node)
 				}
 			}
+
+		case gosax.EventProcessingInstruction:
+			target, data := splitProcessingInstruction(e.Bytes)
+			if string(target) == "xml" {
+				// The XML declaration looks like a PI but is not one
+				p.declaration.Store(parseXMLDeclaration(data))
+			} else if state.streamDepth > 0 {
+				parent := state.stack[len(state.stack)-1]
+				node := getContentNodeFromPool()
+				// The data is stored like a comment's, the target beside it
+				node.start = len(parent.rawContent)
+				parent.rawContent = append(parent.rawContent, data...)
+				node.end = len(parent.rawContent)
+				node.nodeType = processingInstructionNode
+				node.target = string(target)
+				node.cdata = false
+				node.position = state.position()
+				node.parent = parent
+				node.siblingIndex = len(parent.children)
+				parent.children = append(parent.children, node)
+			}
+
+		case gosax.EventDocType:
+			p.docType.Store(parseDocType(e.Bytes))
+		}
+
+		if state.streamDepth == 0 {
+			state.raw = state.raw[:0]
 		}
+		state.advance(e.Bytes)
 	}
 }
 
-func (p *Parser) handleStartElement(state *parseState, ch chan<- *XMLElement, name []byte, attrs []byte, fullTag []byte, elementNamespaces map[string]string) {
+// handleStartElement returns an error if parsing should stop, because the element
+// could not be delivered or, in strict mode, because it is not well-formed
+func (p *Parser) handleStartElement(state *parseState, emit emitFunc, name []byte, attrs []byte, fullTag []byte, elementNamespaces map[string]string) error {
 	nameStr := string(name)
 
+	if p.strict {
+		if state.brokenDepth > 0 {
+			if err := p.resync(state, emit, nameStr); err != nil {
+				return err
+			}
+		}
+		if !isQName(nameStr) {
+			if p.report == nil {
+				return state.parseError(errInvalidElementName(nameStr))
+			}
+			p.report(state.parseError(errInvalidElementName(nameStr)))
+			// Neither the tag nor the record around it can be trusted
+			if state.streamDepth > 0 {
+				state.brokenDepth = len(state.stack)
+			}
+			return nil
+		}
+	}
+
 	// Parse element name for namespace support
 	localName := nameStr
 	prefix := ""
@@ -199,229 +576,337 @@ func (p *Parser) handleStartElement(state *parseState, ch chan<- *XMLElement, na
 	elem.prefix = prefix
 	elem.namespaceURI = namespaceURI
 	elem.namespaces = nsContext
+	elem.position = state.position()
+
+	// Violations in the start tag are reported in the element it opens
+	if p.strict {
+		if i := findStrayAmpersand(fullTag); i != -1 {
+			if err := p.violation(state.startTagError(elem, state.positionAt(fullTag, i), errStrayAmpersand)); err != nil {
+				returnElementToPool(elem)
+				return err
+			}
+		}
+	}
 
 	// Parse attributes only if they exist
 	if len(attrs) > 0 {
-		parseAttributes(attrs, elem)
+		if err := parseAttributes(attrs, elem, p.unquotedAttributes); err != nil && p.strict {
+			if err := p.violation(state.startTagError(elem, elem.position, err)); err != nil {
+				returnElementToPool(elem)
+				return err
+			}
+		}
 	}
 
-	// Set parent relationship
-	if len(state.stack) > 0 {
+	if p.strict {
+		if err := state.checkStartElement(elem); err != nil {
+			if err := p.violation(state.startTagError(elem, elem.position, err)); err != nil {
+				returnElementToPool(elem)
+				return err
+			}
+		}
+	}
+
+	// Set parent relationship only inside a streamed subtree - elements outside
+	// of one are kept on the stack for namespace scoping but never retained
+	if state.streamDepth > 0 {
 		parent := state.stack[len(state.stack)-1]
 		elem.parent = parent
 		elem.siblingIndex = len(parent.children)
 		parent.children = append(parent.children, elem)
 	}
+	elem.streamed, elem.pending = p.selectors.match(state.stack, elem)
 
 	// Check if self-closing tag
 	isSelfClosing := len(fullTag) >= 2 && fullTag[len(fullTag)-2] == '/' && fullTag[len(fullTag)-1] == '>'
 
 	if isSelfClosing {
 		// Handle self-closing tag
-		p.checkAndStreamElement(ch, elem)
-	} else {
-		// Push to stack
-		state.stack = append(state.stack, elem)
-		state.depth++
+		elem.endOffset = state.offset + int64(len(fullTag))
+		return p.checkAndStreamElement(state, emit, elem)
 	}
+
+	// Push to stack
+	state.stack = append(state.stack, elem)
+	state.depth++
+	if elem.streamed {
+		state.streamDepth++
+	}
+	return nil
 }
 
-func (p *Parser) handleEndElement(state *parseState, ch chan<- *XMLElement) {
+// handleEndElement returns an error if parsing should stop, because the element
+// could not be delivered or, in strict mode, because the end tag does not match
+func (p *Parser) handleEndElement(state *parseState, emit emitFunc, endTag []byte) error {
+	if p.strict {
+		if err := state.checkEndTag(endTag); err != nil {
+			if p.report == nil {
+				return state.parseError(err)
+			}
+			return p.recoverEndTag(state, emit, endTag, err)
+		}
+	}
 	if len(state.stack) == 0 {
-		return
+		return nil
 	}
+	return p.closeElement(state, emit, state.offset+int64(len(endTag)))
+}
 
+// closeElement pops the innermost open element, which ends at input offset endOffset,
+// and streams or releases it
+func (p *Parser) closeElement(state *parseState, emit emitFunc, endOffset int64) error {
 	// Pop element from stack
 	elem := state.stack[len(state.stack)-1]
 	state.stack = state.stack[:len(state.stack)-1]
+	state.depth--
+	if elem.streamed {
+		state.streamDepth--
+	}
+	elem.endOffset = endOffset
 
 	// Check if we should stream this element
-	p.checkAndStreamElement(ch, elem)
-
-	state.depth--
+	return p.checkAndStreamElement(state, emit, elem)
 }
 
-func (p *Parser) checkAndStreamElement(ch chan<- *XMLElement, elem *XMLElement) {
-	shouldStream := false
+// checkAndStreamElement is called when elem has been closed. Streamed elements are
+// detached from their parent and passed to emit, elements outside any streamed subtree
+// (including candidates rejected by a filter) are returned to the pool, and
+// elements inside one are left in place.
+// It returns an error if parsing should stop.
+func (p *Parser) checkAndStreamElement(state *parseState, emit emitFunc, elem *XMLElement) error {
+	// Filters that depend on the element's content are decided now it is complete
+	if elem.pending != nil {
+		elem.streamed = matchClosed(elem, elem.pending)
+		elem.pending = nil
+	}
 
-	// Check by name if streamNames is set
-	if len(p.streamNames) > 0 {
-		if p.streamNames[elem.Name] {
-			shouldStream = true
+	// A record in which recovery mode found an unparseable tag is dropped
+	if len(state.stack) < state.brokenDepth {
+		state.brokenDepth = len(state.stack)
+		if elem.streamed && elem.parent == nil {
+			p.report(state.skippedRecord(elem, state.stack))
 		}
+		elem.streamed = false
 	}
 
-	if shouldStream {
-		// Detach from parent for streaming
+	if !elem.streamed {
+		if elem.parent == nil {
+			// Not part of any streamed subtree - nothing references it anymore
+			returnElementToPool(elem)
+		}
+		return nil
+	}
+
+	// Detach from the enclosing streamed element (if any) so the subtree is owned
+	// solely by the consumer. It was just closed, so it is always the last child.
+	if parent := elem.parent; parent != nil {
+		parent.children = parent.children[:len(parent.children)-1]
 		elem.parent = nil
-		// Parent pointers for children are already set correctly during parsing
-		ch <- elem
+		elem.siblingIndex = 0
 	}
-	// Non-streamed elements are not automatically returned to pool.
-	// They remain in memory as children of their parent and will be
-	// returned when the parent is released via Release().
+
+	if p.captureRaw {
+		elem.raw = bytes.Clone(state.raw[elem.position.Offset-state.rawOffset : elem.endOffset-state.rawOffset])
+	}
+	if !emit(elem) {
+		return p.ctx.Err()
+	}
+	return nil
 }
 
-// parseAttributes parses attribute bytes and populates the element's attributes
-func parseAttributes(attrs []byte, elem *XMLElement) {
-	// Count attributes first for better allocation
-	attrCount := 0
-	for i := 0; i < len(attrs); i++ {
-		if attrs[i] == '=' {
-			attrCount++
-		}
+// contextReader stops returning data once its context is cancelled, so a parser
+// whose consumer has gone away abandons the input at the next read
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(b []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return cr.r.Read(b)
+}
 
-	if attrCount == 0 {
-		return
+// attributeScanner splits the attribute part of a start tag into attributes
+type attributeScanner struct {
+	b        []byte
+	i        int
+	unquoted bool // accept values without quotes, which end at whitespace
+}
+
+// done skips whitespace and reports whether there are no attributes left
+func (s *attributeScanner) done() bool {
+	for s.i < len(s.b) && isSpace(s.b[s.i]) {
+		s.i++
 	}
+	return s.i >= len(s.b)
+}
 
-	// Reuse existing slice if it has enough capacity, otherwise allocate
-	if cap(elem.Attributes) >= attrCount {
-		elem.Attributes = elem.Attributes[:0]
-	} else {
-		elem.Attributes = make([]XMLAttribute, 0, attrCount)
+// next returns the name and raw value of the next attribute; done must have returned
+// false. A malformed attribute is skipped up to the next whitespace and reported
+// with an error wrapping ErrNotWellFormed.
+func (s *attributeScanner) next() (name, value []byte, err error) {
+	b := s.b
+	start := s.i
+	for s.i < len(b) && !isSpace(b[s.i]) && b[s.i] != '=' && b[s.i] != '"' && b[s.i] != '\'' {
+		s.i++
+	}
+	name = b[start:s.i]
+	if len(name) == 0 {
+		s.skip()
+		return nil, nil, fmt.Errorf("%w: attribute without name", ErrNotWellFormed)
 	}
 
-	// Simple attribute parser
-	i := 0
-	for i < len(attrs) {
-		// Skip whitespace
-		for i < len(attrs) && (attrs[i] == ' ' || attrs[i] == '\t' || attrs[i] == '\n' || attrs[i] == '\r') {
-			i++
-		}
-		if i >= len(attrs) {
-			break
-		}
+	if s.done() || b[s.i] != '=' {
+		return nil, nil, fmt.Errorf("%w: attribute %s without value", ErrNotWellFormed, name)
+	}
+	s.i++ // '='
+	if s.done() {
+		return nil, nil, fmt.Errorf("%w: attribute %s without value", ErrNotWellFormed, name)
+	}
 
-		// Find attribute name
-		nameStart := i
-		for i < len(attrs) && attrs[i] != '=' {
-			i++
-		}
-		if i >= len(attrs) {
-			break
+	quote := b[s.i]
+	if quote != '"' && quote != '\'' {
+		start = s.i
+		s.skip()
+		if !s.unquoted {
+			return nil, nil, fmt.Errorf("%w: unquoted value of attribute %s", ErrNotWellFormed, name)
 		}
-		name := string(bytes.TrimSpace(attrs[nameStart:i]))
-
-		// Skip '='
-		i++
+		return name, b[start:s.i], nil
+	}
+	end := bytes.IndexByte(b[s.i+1:], quote)
+	if end == -1 {
+		s.i = len(b)
+		return nil, nil, fmt.Errorf("%w: unterminated value of attribute %s", ErrNotWellFormed, name)
+	}
+	value = b[s.i+1 : s.i+1+end]
+	s.i += end + 2
+	return name, value, nil
+}
 
-		// Skip whitespace
-		for i < len(attrs) && (attrs[i] == ' ' || attrs[i] == '\t') {
-			i++
-		}
-		if i >= len(attrs) {
-			break
-		}
+// skip moves past the bytes up to the next whitespace
+func (s *attributeScanner) skip() {
+	for s.i < len(s.b) && !isSpace(s.b[s.i]) {
+		s.i++
+	}
+}
 
-		// Find attribute value (quoted)
-		quote := attrs[i]
-		if quote != '"' && quote != '\'' {
-			break
-		}
-		i++
-		valueStart := i
-		for i < len(attrs) && attrs[i] != quote {
-			i++
+// countAttributes returns the number of '=' outside quoted values in attrs, which
+// is the number of attributes of a well-formed start tag
+func countAttributes(attrs []byte) int {
+	count := 0
+	var quote byte
+	for _, c := range attrs {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			count++
 		}
-		value := string(attrs[valueStart:i])
-		i++ // Skip closing quote
-
-		// Store attribute inline (no allocation, stored in slice backing array)
-		elem.Attributes = append(elem.Attributes, XMLAttribute{Name: name, Value: value})
 	}
+	return count
 }
 
-// extractNamespaces scans attributes for xmlns declarations and returns them
-func (p *Parser) extractNamespaces(attrs []byte) map[string]string {
-	if len(attrs) == 0 {
+// parseAttributes parses attribute bytes and populates the element's attributes.
+// Malformed attributes are left out, as are repeats of an attribute name, of which
+// the first is kept. The first such problem is returned.
+func parseAttributes(attrs []byte, elem *XMLElement, unquoted bool) error {
+	attrCount := countAttributes(attrs)
+	if attrCount == 0 {
+		if len(bytes.Trim(attrs, " \t\r\n")) > 0 {
+			return fmt.Errorf("%w: attribute %s without value", ErrNotWellFormed, bytes.TrimSpace(attrs))
+		}
 		return nil
 	}
-	var namespaces map[string]string
 
-	i := 0
-	for i < len(attrs) {
-		// Skip whitespace
-		for i < len(attrs) && (attrs[i] == ' ' || attrs[i] == '\t' || attrs[i] == '\n' || attrs[i] == '\r') {
-			i++
-		}
-		if i >= len(attrs) {
-			break
-		}
+	// Reuse existing slice if it has enough capacity, otherwise allocate
+	if cap(elem.Attributes) >= attrCount {
+		elem.Attributes = elem.Attributes[:0]
+	} else {
+		elem.Attributes = make([]XMLAttribute, 0, attrCount)
+	}
 
-		// Find attribute name
-		nameStart := i
-		for i < len(attrs) && attrs[i] != '=' {
-			i++
-		}
-		if i >= len(attrs) {
-			break
+	var firstErr error
+	s := attributeScanner{b: attrs, unquoted: unquoted}
+	for !s.done() {
+		nameBytes, valueBytes, err := s.next()
+		if err == nil && elem.hasAttribute(nameBytes) {
+			err = fmt.Errorf("%w: repeated attribute %s in <%s>", ErrNotWellFormed, nameBytes, elem.Name)
 		}
-		nameBytes := bytes.TrimSpace(attrs[nameStart:i])
-
-		// Quick check: skip if not a namespace declaration (early bailout for performance)
-		if len(nameBytes) < 5 || (nameBytes[0] != 'x' && nameBytes[0] != 'X') {
-			// Skip '=' and value quickly
-			i++
-			for i < len(attrs) && (attrs[i] == ' ' || attrs[i] == '\t') {
-				i++
-			}
-			if i < len(attrs) && (attrs[i] == '"' || attrs[i] == '\'') {
-				quote := attrs[i]
-				i++
-				for i < len(attrs) && attrs[i] != quote {
-					i++
-				}
-				i++
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
 			}
 			continue
 		}
 
+		// Store attribute inline (no allocation, stored in slice backing array)
 		name := string(nameBytes)
+		localName, namespaceURI := resolveAttributeName(elem, name)
+		elem.Attributes = append(elem.Attributes, XMLAttribute{Name: name, Value: unescapeString(valueBytes), LocalName: localName, NamespaceURI: namespaceURI})
+	}
+	return firstErr
+}
 
-		// Skip '='
-		i++
-
-		// Skip whitespace
-		for i < len(attrs) && (attrs[i] == ' ' || attrs[i] == '\t') {
-			i++
-		}
-		if i >= len(attrs) {
-			break
+// hasAttribute reports whether elem already has an attribute with the qualified name
+func (e *XMLElement) hasAttribute(name []byte) bool {
+	for i := range e.Attributes {
+		if e.Attributes[i].Name == string(name) {
+			return true
 		}
+	}
+	return false
+}
 
-		// Find attribute value (quoted)
-		quote := attrs[i]
-		if quote != '"' && quote != '\'' {
-			break
+// resolveAttributeName splits an attribute name of elem into its local name and
+// the namespace URI bound to its prefix. elem.namespaces must already be set.
+func resolveAttributeName(elem *XMLElement, name string) (localName, namespaceURI string) {
+	prefix, localName, ok := strings.Cut(name, ":")
+	if !ok {
+		if name == "xmlns" {
+			return name, xmlnsNamespaceURI
 		}
-		i++
-		valueStart := i
-		for i < len(attrs) && attrs[i] != quote {
-			i++
+		// Unprefixed attributes are in no namespace
+		return name, ""
+	}
+	switch prefix {
+	case "xml":
+		return localName, xmlNamespaceURI
+	case "xmlns":
+		return localName, xmlnsNamespaceURI
+	}
+	return localName, elem.namespaces[prefix]
+}
+
+// extractNamespaces scans attributes for xmlns declarations and returns them.
+// Malformed attributes are skipped; parseAttributes reports them.
+func (p *Parser) extractNamespaces(attrs []byte) map[string]string {
+	var namespaces map[string]string
+	s := attributeScanner{b: attrs, unquoted: p.unquotedAttributes}
+	for !s.done() {
+		name, value, err := s.next()
+		if err != nil || !bytes.HasPrefix(name, []byte("xmlns")) {
+			continue
 		}
-		value := string(attrs[valueStart:i])
-		i++ // Skip closing quote
 
-		// Check if it's a namespace declaration
-		if name == "xmlns" {
+		var prefix string
+		switch {
+		case len(name) == len("xmlns"):
 			// Default namespace - store with empty prefix
-			if namespaces == nil {
-				namespaces = make(map[string]string, 2)
-			}
-			namespaces[""] = value
-		} else if strings.HasPrefix(name, "xmlns:") {
-			// Prefixed namespace
-			if namespaces == nil {
-				namespaces = make(map[string]string, 2)
-			}
-			prefix := name[6:] // Remove "xmlns:" prefix
-			namespaces[prefix] = value
+		case name[len("xmlns")] == ':':
+			prefix = string(name[len("xmlns:"):])
+		default:
+			continue
 		}
-	}
-	if namespaces == nil {
-		return nil
+		if namespaces == nil {
+			namespaces = make(map[string]string, 2)
+		}
+		namespaces[prefix] = unescapeString(value)
 	}
 	return namespaces
 }
@@ -1,12 +1,26 @@
 package xmlstreamer
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+	"unicode/utf16"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 	"github.com/wilkmaciej/xpath"
 )
 
@@ -275,64 +289,134 @@ func TestCDATAWithNewlines(t *testing.T) {
 }
 
 // =============================================================================
-// ENTITY TESTS (XML predefined entities)
-// Note: These tests document current behavior - entity decoding may not be implemented
+// ENTITY TESTS (XML predefined entities and character references)
 // =============================================================================
 
 func TestEntityLessThan(t *testing.T) {
 	xml := `<root><item>&lt;tag&gt;</item></root>`
 	elem := parseOne(t, xml, "item")
 
-	// Document current behavior (entities may not be decoded)
-	text := elem.InnerText()
-	t.Logf("Entity &lt;/&gt; result: %q", text)
-	// Expected if decoded: "<tag>"
-	// Current behavior may be: "&lt;tag&gt;"
+	if text := elem.InnerText(); text != "<tag>" {
+		t.Errorf("expected '<tag>', got %q", text)
+	}
 }
 
 func TestEntityAmpersand(t *testing.T) {
 	xml := `<root><item>Tom &amp; Jerry</item></root>`
 	elem := parseOne(t, xml, "item")
 
-	text := elem.InnerText()
-	t.Logf("Entity &amp; result: %q", text)
-	// Expected if decoded: "Tom & Jerry"
+	if text := elem.InnerText(); text != "Tom & Jerry" {
+		t.Errorf("expected 'Tom & Jerry', got %q", text)
+	}
 }
 
 func TestEntityQuotes(t *testing.T) {
 	xml := `<root><item>&quot;quoted&quot; and &apos;apostrophe&apos;</item></root>`
 	elem := parseOne(t, xml, "item")
 
-	text := elem.InnerText()
-	t.Logf("Entity quotes result: %q", text)
-	// Expected if decoded: `"quoted" and 'apostrophe'`
+	expected := `"quoted" and 'apostrophe'`
+	if text := elem.InnerText(); text != expected {
+		t.Errorf("expected %q, got %q", expected, text)
+	}
 }
 
 func TestNumericEntityDecimal(t *testing.T) {
 	xml := `<root><item>&#65;&#66;&#67;</item></root>`
 	elem := parseOne(t, xml, "item")
 
-	text := elem.InnerText()
-	t.Logf("Numeric decimal entity result: %q", text)
-	// Expected if decoded: "ABC"
+	if text := elem.InnerText(); text != "ABC" {
+		t.Errorf("expected 'ABC', got %q", text)
+	}
 }
 
 func TestNumericEntityHex(t *testing.T) {
-	xml := `<root><item>&#x41;&#x42;&#x43;</item></root>`
+	xml := `<root><item>&#x41;&#x42;&#x43; &#x1F389;</item></root>`
 	elem := parseOne(t, xml, "item")
 
-	text := elem.InnerText()
-	t.Logf("Numeric hex entity result: %q", text)
-	// Expected if decoded: "ABC"
+	if text := elem.InnerText(); text != "ABC 🎉" {
+		t.Errorf("expected 'ABC 🎉', got %q", text)
+	}
 }
 
 func TestEntityInAttribute(t *testing.T) {
-	xml := `<root><item name="&lt;value&gt;">text</item></root>`
+	xml := `<root><item name="&lt;value&gt; &amp; &#34;more&#x22;">text</item></root>`
+	elem := parseOne(t, xml, "item")
+
+	if len(elem.Attributes) != 1 {
+		t.Fatalf("expected 1 attribute, got %d", len(elem.Attributes))
+	}
+	expected := `<value> & "more"`
+	if elem.Attributes[0].Value != expected {
+		t.Errorf("expected %q, got %q", expected, elem.Attributes[0].Value)
+	}
+}
+
+func TestEntityInNestedElements(t *testing.T) {
+	xml := `<root><item>a &lt; b<child> &amp; </child>c &gt; d</item></root>`
+	elem := parseOne(t, xml, "item")
+
+	if text := elem.InnerText(); text != "a < b & c > d" {
+		t.Errorf("expected 'a < b & c > d', got %q", text)
+	}
+}
+
+func TestEntityXPathValue(t *testing.T) {
+	xml := `<root><item code="A&amp;B"><name>Fish &amp; Chips</name></item></root>`
+	elem := parseOne(t, xml, "item")
+
+	if v := elem.Evaluate(xpath.MustCompile("string(name)")); v != "Fish & Chips" {
+		t.Errorf("expected 'Fish & Chips', got %q", v)
+	}
+	if v := ElementString(elem.Evaluate(xpath.MustCompile("name/text()"))); v != "Fish & Chips" {
+		t.Errorf("expected 'Fish & Chips', got %q", v)
+	}
+	if v := ElementString(elem.Evaluate(xpath.MustCompile("@code"))); v != "A&B" {
+		t.Errorf("expected 'A&B', got %q", v)
+	}
+}
+
+func TestEntityNotDecodedInCDATA(t *testing.T) {
+	xml := `<root><item><![CDATA[&amp;&lt;]]></item></root>`
+	elem := parseOne(t, xml, "item")
+
+	if text := elem.InnerText(); text != "&amp;&lt;" {
+		t.Errorf("expected CDATA to be kept verbatim, got %q", text)
+	}
+}
+
+func TestNumericEntityLeadingZeros(t *testing.T) {
+	// Any number of leading zeros is allowed, in text and attributes
+	xml := `<root><item a="&#0000000000066;">&#x000000041;&#0000000065;&#x00000000001F389;</item></root>`
 	elem := parseOne(t, xml, "item")
 
-	if len(elem.Attributes) > 0 {
-		t.Logf("Entity in attribute result: %q", elem.Attributes[0].Value)
-		// Expected if decoded: "<value>"
+	if text := elem.InnerText(); text != "AA🎉" {
+		t.Errorf("expected 'AA🎉', got %q", text)
+	}
+	if a := elem.Attributes[0].Value; a != "B" {
+		t.Errorf("expected attribute 'B', got %q", a)
+	}
+}
+
+func TestEntityMalformedPassthrough(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`AT&T`, `AT&T`},
+		{`a & b; c`, `a & b; c`},
+		{`&unknown;`, `&unknown;`},
+		{`&#;&#x;&#xZZ;`, `&#;&#x;&#xZZ;`},
+		{`&#0;&#xD800;&#x110000;`, `&#0;&#xD800;&#x110000;`},
+		{`&#x0000000;&#x000000110000;`, `&#x0000000;&#x000000110000;`},
+		{`&#00000000000000000000000000 x;`, `&#00000000000000000000000000 x;`},
+		{`&amp`, `&amp`},
+		{`&&amp;`, `&&`},
+	}
+	for _, tt := range tests {
+		elem := parseOne(t, `<root><item>`+tt.input+`</item></root>`, "item")
+		if text := elem.InnerText(); text != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, text)
+		}
 	}
 }
 
@@ -535,6 +619,311 @@ func TestStreamNilNames(t *testing.T) {
 	}
 }
 
+func TestStreamedElementDetached(t *testing.T) {
+	xml := `<root><item>1</item><item>2</item></root>`
+	elements := parseAll(t, xml, []string{"item"})
+
+	for i, elem := range elements {
+		if elem.Parent() != nil {
+			t.Errorf("element %d: expected streamed element to have no parent", i)
+		}
+		if elem.siblingIndex != 0 {
+			t.Errorf("element %d: expected sibling index 0, got %d", i, elem.siblingIndex)
+		}
+	}
+}
+
+func TestNestedStreamedElementsDetached(t *testing.T) {
+	xml := `<root><item><name>a</name><child>1</child><child>2</child><tail/></item></root>`
+	elements := parseAll(t, xml, []string{"item", "child"})
+
+	if len(elements) != 3 {
+		t.Fatalf("expected 3 elements, got %d", len(elements))
+	}
+	if elements[0].Name != "child" || elements[1].Name != "child" || elements[2].Name != "item" {
+		t.Fatalf("unexpected emit order: %s, %s, %s", elements[0].Name, elements[1].Name, elements[2].Name)
+	}
+
+	// The streamed children were removed from the enclosing item
+	item := elements[2]
+	var names []string
+	for i, child := range item.children {
+		if child.getSiblingIndex() != i {
+			t.Errorf("child %d: expected sibling index %d, got %d", i, i, child.getSiblingIndex())
+		}
+		if e, ok := child.(*XMLElement); ok {
+			names = append(names, e.Name)
+		}
+	}
+	if strings.Join(names, ",") != "name,tail" {
+		t.Errorf("expected item children [name tail], got %v", names)
+	}
+
+	// Releasing everything must not double-release the nested elements
+	for _, elem := range elements {
+		elem.Release()
+	}
+}
+
+func TestContentOutsideStreamedElementsDiscarded(t *testing.T) {
+	xml := `<root>before<skip><deep>x</deep></skip><item>in</item>after</root>`
+	elem := parseOne(t, xml, "item")
+
+	if elem.InnerText() != "in" {
+		t.Errorf("expected 'in', got %q", elem.InnerText())
+	}
+}
+
+// =============================================================================
+// STREAM SELECTOR TESTS
+// =============================================================================
+
+func streamedTexts(elements []*XMLElement) []string {
+	texts := make([]string, len(elements))
+	for i, elem := range elements {
+		texts[i] = elem.InnerText()
+	}
+	return texts
+}
+
+func TestSelectorPaths(t *testing.T) {
+	xml := `<rss><channel>` +
+		`<item>a</item>` +
+		`<related><item>b</item></related>` +
+		`<item>c</item>` +
+		`</channel><item>d</item></rss>`
+
+	tests := []struct {
+		selector string
+		expected string
+	}{
+		{"item", "a,b,c,d"},
+		{"//item", "a,b,c,d"},
+		{"channel/item", "a,c"},
+		{"//channel/item", "a,c"},
+		{"/rss/channel/item", "a,c"},
+		{"/rss/item", "d"},
+		{"/channel/item", ""},
+		{"rss//item", "a,b,c,d"},
+		{"channel//item", "a,b,c"},
+		{"related/item", "b"},
+		{"channel/*/item", "b"},
+		{"/rss/*", "abc,d"},
+		{"/rss", "abcd"},
+	}
+	for _, tt := range tests {
+		elements := parseAll(t, xml, []string{tt.selector})
+		if got := strings.Join(streamedTexts(elements), ","); got != tt.expected {
+			t.Errorf("selector %q: expected %q, got %q", tt.selector, tt.expected, got)
+		}
+	}
+}
+
+func TestSelectorMultiple(t *testing.T) {
+	xml := `<feed><entry>1</entry><meta><entry>x</entry><link>l</link></meta></feed>`
+	elements := parseAll(t, xml, []string{"/feed/entry", "meta/link"})
+
+	if got := strings.Join(streamedTexts(elements), ","); got != "1,l" {
+		t.Errorf("expected '1,l', got %q", got)
+	}
+}
+
+func TestSelectorPrefixedName(t *testing.T) {
+	xml := `<root xmlns:g="urn:g"><g:list><g:item>1</g:item></g:list><item>2</item></root>`
+	elements := parseAll(t, xml, []string{"g:list/g:item"})
+
+	if got := strings.Join(streamedTexts(elements), ","); got != "1" {
+		t.Errorf("expected '1', got %q", got)
+	}
+}
+
+func TestSelectorClarkNotation(t *testing.T) {
+	const ns = "http://base.google.com/ns/1.0"
+	xml := `<feed xmlns:g="` + ns + `" xmlns:gm="` + ns + `">` +
+		`<g:item>1</g:item>` +
+		`<gm:item>2</gm:item>` +
+		`<item xmlns="` + ns + `">3</item>` +
+		`<item>4</item>` +
+		`<x:item xmlns:x="urn:other">5</x:item>` +
+		`</feed>`
+
+	tests := []struct {
+		selector string
+		expected string
+	}{
+		{"{" + ns + "}item", "1,2,3"},
+		{"/feed/{" + ns + "}item", "1,2,3"},
+		{"{}feed/{" + ns + "}item", "1,2,3"},
+		{"{}item", "4"},
+		{"{urn:other}item", "5"},
+		{"{" + ns + "}*", "1,2,3"},
+		{"g:item", "1"},
+	}
+	for _, tt := range tests {
+		elements := parseAll(t, xml, []string{tt.selector})
+		if got := strings.Join(streamedTexts(elements), ","); got != tt.expected {
+			t.Errorf("selector %q: expected %q, got %q", tt.selector, tt.expected, got)
+		}
+	}
+}
+
+func TestSelectorPredicates(t *testing.T) {
+	xml := `<root>` +
+		`<item id="1" status="active"><category>books</category></item>` +
+		`<item id="2" status="deleted"><category>books</category></item>` +
+		`<item id="3" status="active"><category>music</category><tag/></item>` +
+		`<item id="4"><category>books</category></item>` +
+		`<item id="5" status="active"/>` +
+		`<group kind="a/b"><item id="6" status="active"/></group>` +
+		`</root>`
+
+	tests := []struct {
+		selector string
+		expected string
+	}{
+		{"item[@status='active']", "1,3,5,6"},
+		{"item[@status!='active']", "2"},
+		{"item[not(@status='active')]", "2,4"},
+		{"item[@status]", "1,2,3,5,6"},
+		{"item[category='books']", "1,2,4"},
+		{"item[@status='active'][category='books']", "1"},
+		{"item[@status='active' and category='books']", "1"},
+		{"item[@status='deleted' or category='music']", "2,3"},
+		{"item[tag]", "3"},
+		{"item[not(tag)]", "1,2,4,5,6"},
+		{"item[@id=\"5\" or (@id='4' and category)]", "4,5"},
+		{"group[@kind='a/b']/item", "6"},
+		{"category[.='music']", ""},
+		{"root/item/category[.='music']", ""},
+	}
+	for _, tt := range tests {
+		elements := parseAll(t, xml, []string{tt.selector})
+		ids := make([]string, len(elements))
+		for i, elem := range elements {
+			ids[i] = ElementString(elem.Evaluate(xpath.MustCompile("@id")))
+		}
+		if got := strings.Join(ids, ","); got != tt.expected {
+			t.Errorf("selector %q: expected %q, got %q", tt.selector, tt.expected, got)
+		}
+	}
+
+	categories := parseAll(t, xml, []string{"category[.='music']"})
+	if len(categories) != 1 || categories[0].InnerText() != "music" {
+		t.Errorf("expected the single music category, got %d elements", len(categories))
+	}
+}
+
+func TestSelectorPredicateRejectedNestedCandidate(t *testing.T) {
+	// A rejected candidate inside a streamed element stays part of that element
+	xml := `<root><item><part kind="x">1</part><part kind="y">2</part></item></root>`
+	elements := parseAll(t, xml, []string{"item", "part[.='2']"})
+
+	if len(elements) != 2 {
+		t.Fatalf("expected 2 elements, got %d", len(elements))
+	}
+	if elements[0].Name != "part" || elements[0].InnerText() != "2" {
+		t.Errorf("expected part '2' first, got %s %q", elements[0].Name, elements[0].InnerText())
+	}
+	if elements[1].Name != "item" || elements[1].InnerText() != "1" {
+		t.Errorf("expected item with remaining text '1', got %s %q", elements[1].Name, elements[1].InnerText())
+	}
+}
+
+func TestWithFilter(t *testing.T) {
+	xml := `<root>` +
+		`<item status="active"><category>books</category></item>` +
+		`<item status="active"><category>music</category></item>` +
+		`<product status="active"><category>books</category></product>` +
+		`<item status="hidden"><category>books</category></item>` +
+		`</root>`
+
+	active, err := CompileFilter("@status='active'")
+	if err != nil {
+		t.Fatalf("failed to compile filter: %v", err)
+	}
+	books, err := CompileFilter("category = 'books'")
+	if err != nil {
+		t.Fatalf("failed to compile filter: %v", err)
+	}
+
+	parser := NewParser(context.Background(), strings.NewReader(xml), []string{"item", "product"}, 10, WithFilter(active), WithFilter(books))
+	var names []string
+	for elem := range parser.Stream() {
+		names = append(names, elem.Name)
+		elem.Release()
+	}
+	if got := strings.Join(names, ","); got != "item,product" {
+		t.Errorf("expected 'item,product', got %q", got)
+	}
+}
+
+func TestFilterNamespaces(t *testing.T) {
+	const ns = "http://base.google.com/ns/1.0"
+	xml := `<root xmlns:g="` + ns + `" xmlns:gm="` + ns + `">` +
+		`<item g:flag="1"><g:id>a</g:id></item>` +
+		`<item gm:flag="1"><gm:id>b</gm:id></item>` +
+		`<item flag="1"><id>c</id></item>` +
+		`</root>`
+
+	tests := []struct {
+		filter   string
+		expected string
+	}{
+		{"@{" + ns + "}flag", "a,b"},
+		{"@{}flag", "c"},
+		{"@g:flag", "a"},
+		{"{" + ns + "}id='b'", "b"},
+	}
+	for _, tt := range tests {
+		filter, err := CompileFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("filter %q: failed to compile: %v", tt.filter, err)
+		}
+		parser := NewParser(context.Background(), strings.NewReader(xml), []string{"item"}, 10, WithFilter(filter))
+		var texts []string
+		for elem := range parser.Stream() {
+			texts = append(texts, elem.InnerText())
+		}
+		if got := strings.Join(texts, ","); got != tt.expected {
+			t.Errorf("filter %q: expected %q, got %q", tt.filter, tt.expected, got)
+		}
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	for _, expr := range []string{"", "@", "@a=", "@a='x", "a ==", "not(@a", "(@a", "@a and", ".", "@*", "@a @b"} {
+		if _, err := CompileFilter(expr); err == nil {
+			t.Errorf("filter %q: expected compile error", expr)
+		}
+	}
+	for _, selector := range []string{"item[", "item]", "item[]", "item[@a]x", "a[b='x']/item",
+		"'['", "}{o*o[on}", "a{x[}", "item['x]"} {
+		parser := NewParser(context.Background(), strings.NewReader(`<a/>`), []string{selector}, 1)
+		for range parser.Stream() {
+		}
+		if parser.Err() == nil {
+			t.Errorf("selector %q: expected compile error", selector)
+		}
+	}
+}
+
+func TestSelectorInvalid(t *testing.T) {
+	for _, selector := range []string{"", "/", "//", "a/", "a///b", "a//", "{urn:x", "{urn:x}", "{urn:x}g:item", "{urn:x}1a",
+		"'", "a b", "}x", "1item", "a:b:c", ":a", "a:", "g:*", "feed/g:*"} {
+		parser := NewParser(context.Background(), strings.NewReader(`<a><b/></a>`), []string{selector}, 1)
+		count := 0
+		for range parser.Stream() {
+			count++
+		}
+		if count != 0 {
+			t.Errorf("selector %q: expected no elements, got %d", selector, count)
+		}
+		if parser.Err() == nil {
+			t.Errorf("selector %q: expected compile error", selector)
+		}
+	}
+}
+
 // =============================================================================
 // CONTEXT CANCELLATION TESTS
 // =============================================================================
@@ -557,6 +946,101 @@ func TestContextCancellation(t *testing.T) {
 	t.Logf("Received %d elements before/after cancellation", count)
 }
 
+// infiniteItemReader produces an endless stream of <item> elements
+type infiniteItemReader struct {
+	started bool
+}
+
+func (r *infiniteItemReader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		return copy(p, "<root>"), nil
+	}
+	const item = "<item>x</item>"
+	n := 0
+	for n+len(item) <= len(p) {
+		n += copy(p[n:], item)
+	}
+	return n, nil
+}
+
+func TestAbandonedConsumerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	parser := NewParser(ctx, &infiniteItemReader{}, []string{"item"}, 1)
+
+	ch := parser.Stream()
+	<-ch
+	// Stop ranging without draining and cancel - the goroutine must not stay blocked on send
+	cancel()
+
+	select {
+	case <-parser.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("parser goroutine did not exit after context cancellation")
+	}
+	if !errors.Is(parser.Err(), context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", parser.Err())
+	}
+}
+
+func TestCloseStopsParser(t *testing.T) {
+	parser := NewParser(context.Background(), &infiniteItemReader{}, []string{"item"}, 1)
+
+	count := 0
+	for elem := range parser.Stream() {
+		elem.Release()
+		count++
+		if count == 3 {
+			break
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = parser.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+
+	// Goroutine has exited and the channel is closed
+	if _, ok := <-parser.Stream(); ok {
+		t.Error("expected Stream channel to be closed after Close")
+	}
+	if !errors.Is(parser.Err(), context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", parser.Err())
+	}
+	// Second Close is a no-op
+	_ = parser.Close()
+}
+
+func TestCloseBeforeStream(t *testing.T) {
+	parser := NewParser(context.Background(), strings.NewReader(`<root><item/></root>`), []string{"item"}, 1)
+	_ = parser.Close()
+
+	count := 0
+	for range parser.Stream() {
+		count++
+	}
+	if count != 0 {
+		t.Errorf("expected no elements after Close, got %d", count)
+	}
+}
+
+func TestCloseAfterCompletion(t *testing.T) {
+	parser := NewParser(context.Background(), strings.NewReader(`<root><item/></root>`), []string{"item"}, 1)
+	for range parser.Stream() {
+	}
+	_ = parser.Close()
+
+	if err := parser.Err(); err != nil {
+		t.Errorf("expected nil error after completed parse, got %v", err)
+	}
+}
+
 // =============================================================================
 // XPATH NODE TYPE TESTS
 // =============================================================================
@@ -860,6 +1344,57 @@ func TestReleaseWithChildren(t *testing.T) {
 	elem.Release()
 }
 
+// cancelReader returns data, then cancels its context on the next read
+type cancelReader struct {
+	data   []byte
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		r.cancel()
+		return 0, context.Canceled
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReleaseOpenSubtreeOnce(t *testing.T) {
+	// Elements left open inside a streamed subtree when parsing stops go back to
+	// the pool once; released twice, the pool would hand the same element out twice
+	const doc = `<root><item><sub><deeper>text`
+	tests := []struct {
+		name   string
+		reader func(cancel context.CancelFunc) io.Reader
+	}{
+		{"cut off", func(context.CancelFunc) io.Reader { return strings.NewReader(doc) }},
+		{"cancelled", func(cancel context.CancelFunc) io.Reader { return &cancelReader{data: []byte(doc), cancel: cancel} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			parser := NewParser(ctx, tt.reader(cancel), []string{"item"}, 10)
+			for range parser.Stream() {
+				t.Error("expected no element to be streamed")
+			}
+
+			seen := make(map[*XMLElement]bool)
+			for range 8 {
+				elem := getElementFromPool()
+				if seen[elem] {
+					t.Fatal("pool returned the same element twice")
+				}
+				seen[elem] = true
+			}
+			for elem := range seen {
+				returnElementToPool(elem)
+			}
+		})
+	}
+}
+
 // =============================================================================
 // EDGE CASES AND SPECIAL XML
 // =============================================================================
@@ -1060,6 +1595,86 @@ func TestNegativeBufferSize(t *testing.T) {
 	}
 }
 
+// =============================================================================
+// OPTIONS TESTS
+// =============================================================================
+
+func TestNewParserWithOptions(t *testing.T) {
+	xml := `<root><item>1</item><other>x</other><item>2</item></root>`
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(xml),
+		WithStreamSelectors("item"),
+		WithChannelSize(3),
+		WithReadBufferSize(16),
+	)
+
+	if cap(parser.Stream()) != 3 {
+		t.Errorf("expected channel size 3, got %d", cap(parser.Stream()))
+	}
+	var texts []string
+	for elem := range parser.Stream() {
+		texts = append(texts, elem.InnerText())
+	}
+	if got := strings.Join(texts, ","); got != "1,2" {
+		t.Errorf("expected '1,2', got %q", got)
+	}
+	if err := parser.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewParserWithOptionsDefaults(t *testing.T) {
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(`<root><item/></root>`))
+
+	if parser.bufferSize != defaultChannelSize {
+		t.Errorf("expected default channel size %d, got %d", defaultChannelSize, parser.bufferSize)
+	}
+	if parser.readBufferSize != defaultReadBufferSize {
+		t.Errorf("expected default read buffer size %d, got %d", defaultReadBufferSize, parser.readBufferSize)
+	}
+	count := 0
+	for range parser.Stream() {
+		count++
+	}
+	if count != 0 {
+		t.Errorf("expected nothing streamed without selectors, got %d", count)
+	}
+}
+
+func TestWithStreamSelectorsAccumulates(t *testing.T) {
+	xml := `<root><a/><b/><c/></root>`
+	parser := NewParser(context.Background(), strings.NewReader(xml), []string{"a"}, 0, WithStreamSelectors("c"))
+
+	var names []string
+	for elem := range parser.Stream() {
+		names = append(names, elem.Name)
+	}
+	if got := strings.Join(names, ","); got != "a,c" {
+		t.Errorf("expected 'a,c', got %q", got)
+	}
+}
+
+func TestSmallReadBufferLargeTokens(t *testing.T) {
+	// Tokens larger than the read buffer must still be parsed correctly
+	longText := strings.Repeat("x", 10000)
+	longAttr := strings.Repeat("y", 5000)
+	xml := `<root><item attr="` + longAttr + `">` + longText + `</item></root>`
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(xml), WithStreamSelectors("item"), WithReadBufferSize(64))
+
+	var elements []*XMLElement
+	for elem := range parser.Stream() {
+		elements = append(elements, elem)
+	}
+	if len(elements) != 1 {
+		t.Fatalf("expected 1 element, got %d", len(elements))
+	}
+	if elements[0].InnerText() != longText {
+		t.Errorf("expected %d chars of text, got %d", len(longText), len(elements[0].InnerText()))
+	}
+	if elements[0].Attributes[0].Value != longAttr {
+		t.Errorf("expected %d chars of attribute, got %d", len(longAttr), len(elements[0].Attributes[0].Value))
+	}
+}
+
 // =============================================================================
 // REAL-WORLD XML PATTERNS
 // =============================================================================
@@ -1211,6 +1826,23 @@ func BenchmarkParseLarge(b *testing.B) {
 	}
 }
 
+func BenchmarkParseLargeAll(b *testing.B) {
+	var items []string
+	for i := 0; i < 10000; i++ {
+		items = append(items, `<item><name>Item</name><value>12345</value></item>`)
+	}
+	xml := `<root>` + strings.Join(items, "") + `</root>`
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser := NewParser(ctx, strings.NewReader(xml), []string{"item"}, 0)
+		for elem := range parser.All() {
+			elem.Release()
+		}
+	}
+}
+
 func BenchmarkXPathQuery(b *testing.B) {
 	xml := `<root><parent><child>1</child><child>2</child><child>3</child></parent></root>`
 	ctx := context.Background()
@@ -1519,15 +2151,245 @@ func TestMultipleStreamCalls(t *testing.T) {
 }
 
 // =============================================================================
-// CONCURRENT RELEASE TESTS
+// ITERATOR TESTS
 // =============================================================================
 
-func TestConcurrentRelease(t *testing.T) {
-	xml := `<root>`
-	for i := 0; i < 100; i++ {
-		xml += `<item><child>text</child></item>`
-	}
-	xml += `</root>`
+func TestAll(t *testing.T) {
+	xml := `<root><item>1</item><item>2</item><item>3</item></root>`
+	parser := NewParser(context.Background(), strings.NewReader(xml), []string{"item"}, 0)
+
+	var texts []string
+	for elem, err := range parser.All() {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// InnerText is zero-copy, so copy it before releasing the element
+		texts = append(texts, strings.Clone(elem.InnerText()))
+		elem.Release()
+	}
+	if got := strings.Join(texts, ","); got != "1,2,3" {
+		t.Errorf("expected '1,2,3', got %q", got)
+	}
+	if err := parser.Err(); err != nil {
+		t.Errorf("expected nil Err after complete iteration, got %v", err)
+	}
+}
+
+func TestAllBreak(t *testing.T) {
+	parser := NewParser(context.Background(), &infiniteItemReader{}, []string{"item"}, 0)
+
+	count := 0
+	for elem, err := range parser.All() {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		elem.Release()
+		count++
+		if count == 5 {
+			break
+		}
+	}
+	if count != 5 {
+		t.Errorf("expected 5 elements, got %d", count)
+	}
+	if !errors.Is(parser.Err(), context.Canceled) {
+		t.Errorf("expected context.Canceled after break, got %v", parser.Err())
+	}
+	// Close after break returns immediately
+	_ = parser.Close()
+}
+
+func TestAllError(t *testing.T) {
+	reader := &errorReader{
+		data: []byte(`<root><item>1</item><item>2</item><item`),
+		err:  io.ErrUnexpectedEOF,
+	}
+	parser := NewParser(context.Background(), reader, []string{"item"}, 0)
+
+	count := 0
+	var lastErr error
+	for elem, err := range parser.All() {
+		if err != nil {
+			if elem != nil {
+				t.Error("expected nil element with error")
+			}
+			lastErr = err
+			continue
+		}
+		count++
+	}
+	if count != 2 {
+		t.Errorf("expected 2 elements before error, got %d", count)
+	}
+	if !errors.Is(lastErr, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", lastErr)
+	}
+	if lastErr != parser.Err() {
+		t.Errorf("expected yielded error to match Err(), got %v and %v", lastErr, parser.Err())
+	}
+}
+
+func TestAllAfterStream(t *testing.T) {
+	parser := NewParser(context.Background(), strings.NewReader(`<root><item/></root>`), []string{"item"}, 0)
+	for range parser.Stream() {
+	}
+
+	for elem, err := range parser.All() {
+		if elem != nil || err == nil {
+			t.Errorf("expected only an error from All after Stream, got %v, %v", elem, err)
+		}
+	}
+}
+
+func TestStreamAfterAll(t *testing.T) {
+	parser := NewParser(context.Background(), strings.NewReader(`<root><item/></root>`), []string{"item"}, 0)
+	for range parser.All() {
+	}
+
+	if _, ok := <-parser.Stream(); ok {
+		t.Error("expected closed channel from Stream after All")
+	}
+}
+
+// =============================================================================
+// PARALLEL PROCESSING TESTS
+// =============================================================================
+
+func numberedItems(n int) string {
+	var sb strings.Builder
+	sb.WriteString("<root>")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `<item id="%d"><value>%d</value></item>`, i, i)
+	}
+	sb.WriteString("</root>")
+	return sb.String()
+}
+
+func TestProcessParallelOrdered(t *testing.T) {
+	parser := NewParser(context.Background(), strings.NewReader(numberedItems(500)), []string{"item"}, 0)
+
+	next := 0
+	for v, err := range ProcessParallel(parser, 8, true, func() func(*XMLElement) (int, error) {
+		// Expressions are compiled per worker
+		expr := xpath.MustCompile("value")
+		return func(elem *XMLElement) (int, error) {
+			// Uneven work so completion order differs from document order
+			id, _ := strconv.Atoi(ElementString(elem.Evaluate(expr)))
+			if id%7 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			return id, nil
+		}
+	}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v != next {
+			t.Fatalf("expected result %d, got %d", next, v)
+		}
+		next++
+	}
+	if next != 500 {
+		t.Errorf("expected 500 results, got %d", next)
+	}
+}
+
+func TestProcessParallelUnordered(t *testing.T) {
+	parser := NewParser(context.Background(), strings.NewReader(numberedItems(200)), []string{"item"}, 0)
+
+	seen := make(map[string]bool)
+	for v, err := range ProcessParallel(parser, 0, false, func() func(*XMLElement) (string, error) {
+		expr := xpath.MustCompile("@id")
+		return func(elem *XMLElement) (string, error) {
+			// Copy - the element is released after the callback
+			return strings.Clone(ElementString(elem.Evaluate(expr))), nil
+		}
+	}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if seen[v] {
+			t.Errorf("duplicate result %q", v)
+		}
+		seen[v] = true
+	}
+	if len(seen) != 200 {
+		t.Errorf("expected 200 distinct results, got %d", len(seen))
+	}
+}
+
+func TestProcessParallelErrors(t *testing.T) {
+	reader := &errorReader{
+		data: []byte(numberedItems(10)[:len(numberedItems(10))-len("</root>")] + "<item"),
+		err:  io.ErrUnexpectedEOF,
+	}
+	parser := NewParser(context.Background(), reader, []string{"item"}, 0)
+	errOdd := errors.New("odd")
+
+	values, callbackErrs := 0, 0
+	var lastErr error
+	for _, err := range ProcessParallel(parser, 4, true, func() func(*XMLElement) (int, error) {
+		expr := xpath.MustCompile("@id")
+		return func(elem *XMLElement) (int, error) {
+			id, _ := strconv.Atoi(ElementString(elem.Evaluate(expr)))
+			if id%2 == 1 {
+				return 0, errOdd
+			}
+			return id, nil
+		}
+	}) {
+		switch {
+		case errors.Is(err, errOdd):
+			callbackErrs++
+		case err != nil:
+			lastErr = err
+		default:
+			values++
+		}
+	}
+	if values != 5 || callbackErrs != 5 {
+		t.Errorf("expected 5 values and 5 callback errors, got %d and %d", values, callbackErrs)
+	}
+	if !errors.Is(lastErr, io.ErrUnexpectedEOF) {
+		t.Errorf("expected parser error last, got %v", lastErr)
+	}
+}
+
+func TestProcessParallelBreak(t *testing.T) {
+	parser := NewParser(context.Background(), &infiniteItemReader{}, []string{"item"}, 0)
+
+	count := 0
+	for _, err := range ProcessParallel(parser, 4, true, func() func(*XMLElement) (string, error) {
+		return func(elem *XMLElement) (string, error) {
+			return elem.Name, nil
+		}
+	}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		count++
+		if count == 100 {
+			break
+		}
+	}
+
+	select {
+	case <-parser.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("parser goroutine still running after break")
+	}
+}
+
+// =============================================================================
+// CONCURRENT RELEASE TESTS
+// =============================================================================
+
+func TestConcurrentRelease(t *testing.T) {
+	xml := `<root>`
+	for i := 0; i < 100; i++ {
+		xml += `<item><child>text</child></item>`
+	}
+	xml += `</root>`
 
 	elements := parseAll(t, xml, []string{"item"})
 	if len(elements) != 100 {
@@ -1585,6 +2447,18 @@ func TestErrorReaderMidStream(t *testing.T) {
 	if count != 2 {
 		t.Errorf("expected 2 complete elements before error, got %d", count)
 	}
+
+	err := parser.Err()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError, got %T", err)
+	}
+	if parseErr.Offset != 34 || parseErr.Line != 1 || parseErr.Column != 35 {
+		t.Errorf("expected offset 34 at line 1, column 35, got offset %d at %s", parseErr.Offset, parseErr.Position)
+	}
 }
 
 func TestErrorReaderEmpty(t *testing.T) {
@@ -1603,6 +2477,101 @@ func TestErrorReaderEmpty(t *testing.T) {
 	if count != 0 {
 		t.Errorf("expected 0 elements from error reader, got %d", count)
 	}
+	if !errors.Is(parser.Err(), io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", parser.Err())
+	}
+}
+
+func TestErrCleanEOF(t *testing.T) {
+	xml := `<root><item>1</item></root>`
+	parser := NewParser(context.Background(), strings.NewReader(xml), []string{"item"}, 10)
+
+	for range parser.Stream() {
+	}
+	if err := parser.Err(); err != nil {
+		t.Errorf("expected nil error for complete document, got %v", err)
+	}
+}
+
+func TestErrTruncatedMarkup(t *testing.T) {
+	// Input ends cleanly (io.EOF) but inside a start tag
+	xml := "<root>\n  <item>1</item>\n  <item attr=\"x"
+	parser := NewParser(context.Background(), strings.NewReader(xml), []string{"item"}, 10)
+
+	count := 0
+	for range parser.Stream() {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("expected 1 element before truncation, got %d", count)
+	}
+
+	var parseErr *ParseError
+	if !errors.As(parser.Err(), &parseErr) {
+		t.Fatalf("expected *ParseError, got %v", parser.Err())
+	}
+	if !errors.Is(parseErr, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", parseErr.Err)
+	}
+	if parseErr.Line != 3 || parseErr.Column != 3 {
+		t.Errorf("expected line 3, column 3, got %s", parseErr.Position)
+	}
+}
+
+func TestErrTruncatedBetweenElements(t *testing.T) {
+	// Input ends without error, in text or between tags, with elements still open
+	tests := []struct {
+		name   string
+		xml    string
+		offset int64
+		path   string
+	}{
+		{"inside text", "<r><item>1</item><item>ab", 25, "/r/item"},
+		{"between tags", "<r><item>1</item>", 17, "/r"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, strict := range []bool{false, true} {
+				opts := []Option{WithStreamSelectors("item")}
+				if strict {
+					opts = append(opts, WithStrict())
+				}
+				parser := NewParserWithOptions(context.Background(), strings.NewReader(tt.xml), opts...)
+				count := 0
+				for elem := range parser.Stream() {
+					count++
+					elem.Release()
+				}
+				// The open <item> of the first case is not streamed
+				if count != 1 {
+					t.Errorf("expected 1 element before truncation, got %d", count)
+				}
+
+				var parseErr *ParseError
+				if !errors.As(parser.Err(), &parseErr) {
+					t.Fatalf("expected *ParseError, got %v", parser.Err())
+				}
+				if !errors.Is(parseErr, io.ErrUnexpectedEOF) {
+					t.Errorf("expected io.ErrUnexpectedEOF, got %v", parseErr.Err)
+				}
+				if parseErr.Offset != tt.offset || parseErr.Path != tt.path {
+					t.Errorf("expected offset %d in %s, got offset %d in %s", tt.offset, tt.path, parseErr.Offset, parseErr.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestErrContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	parser := NewParser(ctx, strings.NewReader(`<root><item>1</item></root>`), []string{"item"}, 10)
+
+	for range parser.Stream() {
+	}
+	if !errors.Is(parser.Err(), context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", parser.Err())
+	}
 }
 
 func BenchmarkElementRelease(b *testing.B) {
@@ -1617,3 +2586,1890 @@ func BenchmarkElementRelease(b *testing.B) {
 		}
 	}
 }
+
+// =============================================================================
+// SERIALIZATION TESTS
+// =============================================================================
+
+func TestOuterXML(t *testing.T) {
+	tests := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"simple", `<root><item id="1">text</item></root>`, `<item id="1">text</item>`},
+		{"empty", `<root><item a="x"></item></root>`, `<item a="x"/>`},
+		{"nested", `<root><item><title>T</title><empty/></item></root>`, `<item><title>T</title><empty/></item>`},
+		{"escaped text", `<root><item>a &lt; b &amp;&amp; c &gt; d</item></root>`, `<item>a &lt; b &amp;&amp; c &gt; d</item>`},
+		{"escaped attribute", `<root><item v="&quot;x&quot; &amp; &lt;y&gt; &#9;"/></root>`, `<item v="&quot;x&quot; &amp; &lt;y> &#x9;"/>`},
+		{"cdata", `<root><item><![CDATA[<b>bold</b>]]></item></root>`, `<item><![CDATA[<b>bold</b>]]></item>`},
+		{"comment", `<root><item><!-- note -->x</item></root>`, `<item><!-- note -->x</item>`},
+		{"own namespace", `<root><item xmlns:g="urn:g" g:a="1">x</item></root>`, `<item xmlns:g="urn:g" g:a="1">x</item>`},
+		{
+			"inherited namespaces",
+			`<root xmlns="urn:d" xmlns:g="urn:g"><item><g:id>1</g:id></item></root>`,
+			`<item xmlns="urn:d" xmlns:g="urn:g"><g:id>1</g:id></item>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elem := parseOne(t, tt.xml, "item")
+			if got := elem.OuterXML(); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOuterXMLCDATAEnd(t *testing.T) {
+	elem := parseOne(t, `<root><item><![CDATA[a]]]]><![CDATA[>b]]></item></root>`, "item")
+	if elem.InnerText() != "a]]>b" {
+		t.Fatalf("expected 'a]]>b', got %q", elem.InnerText())
+	}
+
+	// The serialized form must parse back to the same text
+	again := parseOne(t, "<root>"+elem.OuterXML()+"</root>", "item")
+	if again.InnerText() != "a]]>b" {
+		t.Errorf("expected 'a]]>b' after round trip, got %q (from %q)", again.InnerText(), elem.OuterXML())
+	}
+}
+
+func TestOuterXMLRoundTrip(t *testing.T) {
+	xml := `<rss xmlns:g="http://base.google.com/ns/1.0"><channel>` +
+		`<item lang="en" note="a&amp;b"><title>A &lt;B&gt;</title><g:price>1.00</g:price><!--c--><![CDATA[x<y]]></item>` +
+		`</channel></rss>`
+	elem := parseOne(t, xml, "item")
+	first := elem.OuterXML()
+
+	again := parseOne(t, first, "item")
+	if second := again.OuterXML(); second != first {
+		t.Errorf("round trip changed markup:\n%s\n%s", first, second)
+	}
+	exp := xpath.MustCompile("string(g:price)")
+	if got := again.Evaluate(exp); got != "1.00" {
+		t.Errorf("expected g:price 1.00 after round trip, got %v", got)
+	}
+}
+
+func TestInnerXML(t *testing.T) {
+	elem := parseOne(t, `<root xmlns:g="urn:g"><item id="1">a<g:b>&amp;</g:b><c/></item></root>`, "item")
+	if got, expected := elem.InnerXML(), `a<g:b>&amp;</g:b><c/>`; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	empty := parseOne(t, `<root><item/></root>`, "item")
+	if got := empty.InnerXML(); got != "" {
+		t.Errorf("expected empty InnerXML, got %q", got)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	elem := parseOne(t, `<root><item id="1"><name>x</name></item></root>`, "item")
+
+	var sb strings.Builder
+	n, err := elem.WriteTo(&sb)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if sb.String() != elem.OuterXML() {
+		t.Errorf("expected %q, got %q", elem.OuterXML(), sb.String())
+	}
+	if n != int64(sb.Len()) {
+		t.Errorf("expected %d bytes written, got %d", sb.Len(), n)
+	}
+}
+
+// =============================================================================
+// RAW CAPTURE TESTS
+// =============================================================================
+
+func parseRaw(t *testing.T, xml string, opts ...Option) []*XMLElement {
+	t.Helper()
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(xml), append([]Option{WithRawCapture()}, opts...)...)
+	var elements []*XMLElement
+	for elem, err := range parser.All() {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		elements = append(elements, elem)
+	}
+	return elements
+}
+
+func TestRawCapture(t *testing.T) {
+	items := []string{
+		`<item  id='1'	 name = "a&amp;b" >x &#65; <![CDATA[<y>]]><!-- c --></item>`,
+		"<item\n  id=\"2\"\n/>",
+		`<g:item xmlns:g="urn:g"><g:v>3</g:v></g:item>`,
+	}
+	xml := `<?xml version="1.0"?>` + "\n<root>\n  " + strings.Join(items, "\n  ") + "\n</root>"
+
+	for _, bufSize := range []int{0, 16} {
+		elements := parseRaw(t, xml, WithStreamSelectors("item", "g:item"), WithReadBufferSize(bufSize))
+		if len(elements) != len(items) {
+			t.Fatalf("expected %d elements, got %d", len(items), len(elements))
+		}
+		for i, elem := range elements {
+			if string(elem.Raw()) != items[i] {
+				t.Errorf("buffer %d: expected raw %q, got %q", bufSize, items[i], elem.Raw())
+			}
+			if got := xml[elem.StartOffset():elem.EndOffset()]; got != items[i] {
+				t.Errorf("buffer %d: offsets [%d,%d) select %q", bufSize, elem.StartOffset(), elem.EndOffset(), got)
+			}
+		}
+	}
+}
+
+func TestRawCaptureNested(t *testing.T) {
+	xml := `<root><outer a="1"><skip>s</skip><inner>i</inner><inner/></outer></root>`
+	elements := parseRaw(t, xml, WithStreamSelectors("outer", "inner"))
+
+	expected := []string{`<inner>i</inner>`, `<inner/>`, `<outer a="1"><skip>s</skip><inner>i</inner><inner/></outer>`}
+	if len(elements) != len(expected) {
+		t.Fatalf("expected %d elements, got %d", len(expected), len(elements))
+	}
+	for i, elem := range elements {
+		if string(elem.Raw()) != expected[i] {
+			t.Errorf("expected raw %q, got %q", expected[i], elem.Raw())
+		}
+	}
+}
+
+func TestRawCaptureWithFilter(t *testing.T) {
+	xml := `<root><item><k>no</k></item><item><k>yes</k></item></root>`
+	elements := parseRaw(t, xml, WithStreamSelectors("item[k='yes']"))
+	if len(elements) != 1 {
+		t.Fatalf("expected 1 element, got %d", len(elements))
+	}
+	if got := string(elements[0].Raw()); got != `<item><k>yes</k></item>` {
+		t.Errorf("unexpected raw %q", got)
+	}
+}
+
+func TestRawCaptureDisabled(t *testing.T) {
+	xml := `<root><item>1</item></root>`
+	elem := parseOne(t, xml, "item")
+	if elem.Raw() != nil {
+		t.Errorf("expected no raw bytes without WithRawCapture, got %q", elem.Raw())
+	}
+	// Offsets are tracked regardless
+	if got := xml[elem.StartOffset():elem.EndOffset()]; got != `<item>1</item>` {
+		t.Errorf("offsets [%d,%d) select %q", elem.StartOffset(), elem.EndOffset(), got)
+	}
+}
+
+// =============================================================================
+// POSITION TESTS
+// =============================================================================
+
+func TestElementPosition(t *testing.T) {
+	xml := "<root>\n" +
+		"  <item id=\"1\">\n" +
+		"    <name>a</name><!--c-->\n" +
+		"  </item>\n" +
+		"\t<item/>\n" +
+		"</root>"
+	elements := parseAll(t, xml, []string{"item"})
+	if len(elements) != 2 {
+		t.Fatalf("expected 2 elements, got %d", len(elements))
+	}
+
+	first, second := elements[0], elements[1]
+	if got, expected := first.Position(), (Position{Offset: 9, Line: 2, Column: 3}); got != expected {
+		t.Errorf("expected first item at %+v, got %+v", expected, got)
+	}
+	if got, expected := second.Position(), (Position{Offset: 61, Line: 5, Column: 2}); got != expected {
+		t.Errorf("expected second item at %+v, got %+v", expected, got)
+	}
+	if second.StartOffset() != second.Position().Offset {
+		t.Errorf("StartOffset %d does not match Position offset %d", second.StartOffset(), second.Position().Offset)
+	}
+
+	name := first.children[1].(*XMLElement)
+	if got, expected := name.Position(), (Position{Offset: 27, Line: 3, Column: 5}); got != expected {
+		t.Errorf("expected name at %+v, got %+v", expected, got)
+	}
+	if got, expected := name.children[0].Position(), (Position{Offset: 33, Line: 3, Column: 11}); got != expected {
+		t.Errorf("expected text at %+v, got %+v", expected, got)
+	}
+	comment := first.children[2].(*XMLContentNode)
+	if got, expected := comment.Position(), (Position{Offset: 41, Line: 3, Column: 19}); got != expected {
+		t.Errorf("expected comment at %+v, got %+v", expected, got)
+	}
+}
+
+func TestParseErrorElement(t *testing.T) {
+	xml := "<root>\n  <item>\n    <price>1</price>\n    <name attr=\"x"
+	parser := NewParser(context.Background(), strings.NewReader(xml), []string{"item"}, 10)
+	for range parser.Stream() {
+	}
+
+	var parseErr *ParseError
+	if !errors.As(parser.Err(), &parseErr) {
+		t.Fatalf("expected *ParseError, got %v", parser.Err())
+	}
+	if parseErr.Element != "item" {
+		t.Errorf("expected innermost open element item, got %q", parseErr.Element)
+	}
+	if got, expected := parseErr.ElementPosition, (Position{Offset: 9, Line: 2, Column: 3}); got != expected {
+		t.Errorf("expected element position %+v, got %+v", expected, got)
+	}
+	if msg := parseErr.Error(); !strings.Contains(msg, "in <item> at line 2, column 3") {
+		t.Errorf("expected error message to name the open element, got %q", msg)
+	}
+}
+
+// =============================================================================
+// DECODE TESTS
+// =============================================================================
+
+type decodeOffer struct {
+	XMLName     xml.Name      `xml:"offer"`
+	ID          int           `xml:"id,attr"`
+	Available   bool          `xml:"available,attr"`
+	Lang        string        `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Name        string        `xml:"name"`
+	Price       float64       `xml:"price"`
+	GoogleID    string        `xml:"http://base.google.com/ns/1.0 id"`
+	Categories  []string      `xml:"categories>category"`
+	Params      []decodeParam `xml:"param"`
+	Seller      *decodeSeller `xml:"seller"`
+	Missing     *string       `xml:"missing"`
+	Updated     time.Time     `xml:"updated"`
+	Description string        `xml:"description"`
+	Note        string        `xml:",comment"`
+	FirstImage  string        `xpath:"images/image[1]/@url"`
+	ImageCount  int           `xpath:"count(images/image)"`
+	ImageURLs   []string      `xpath:"images/image/@url"`
+	HasSeller   bool          `xpath:"boolean(seller)"`
+	Skipped     string        `xml:"-"`
+}
+
+type decodeParam struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type decodeSeller struct {
+	decodeContact
+	Name string `xml:"name"`
+}
+
+type decodeContact struct {
+	Email string `xml:"email"`
+}
+
+const decodeOfferXML = `<feed xmlns:g="http://base.google.com/ns/1.0"><offer id=" 42 " available="true" xml:lang="pl">` +
+	`<name>Widget &amp; Co</name><price> 9.99 </price><g:id>G-1</g:id><id>not this one</id>` +
+	`<categories><category>a</category><category>b</category></categories>` +
+	`<param name="color">red</param><param name="size">L<!--x--></param>` +
+	`<seller><name>Shop</name><email>shop@example.com</email></seller>` +
+	`<updated>2024-05-01T10:00:00Z</updated>` +
+	`<description>short <b>bold</b> tail</description><!--note-->` +
+	`<images><image url="1.jpg"/><image url="2.jpg"/></images>` +
+	`<Skipped>x</Skipped></offer></feed>`
+
+func TestDecode(t *testing.T) {
+	elem := parseOne(t, decodeOfferXML, "offer")
+
+	var offer decodeOffer
+	if err := elem.Decode(&offer); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	elem.Release()
+
+	expected := decodeOffer{
+		XMLName:     xml.Name{Local: "offer"},
+		ID:          42,
+		Available:   true,
+		Lang:        "pl",
+		Name:        "Widget & Co",
+		Price:       9.99,
+		GoogleID:    "G-1",
+		Categories:  []string{"a", "b"},
+		Params:      []decodeParam{{"color", "red"}, {"size", "L"}},
+		Seller:      &decodeSeller{decodeContact{"shop@example.com"}, "Shop"},
+		Updated:     time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Description: "short  tail",
+		Note:        "note",
+		FirstImage:  "1.jpg",
+		ImageCount:  2,
+		ImageURLs:   []string{"1.jpg", "2.jpg"},
+		HasSeller:   true,
+	}
+	if !reflect.DeepEqual(offer, expected) {
+		t.Errorf("unexpected result:\n got %+v\nwant %+v", offer, expected)
+	}
+}
+
+func TestDecodeMatchesEncodingXML(t *testing.T) {
+	type item struct {
+		ID    string   `xml:"id,attr"`
+		Title string   `xml:"title"`
+		Tags  []string `xml:"tags>tag"`
+		Body  string   `xml:",innerxml"`
+		Other []string `xml:",any"`
+	}
+	xmlText := `<item id="7"><title>T</title><tags><tag>x</tag><tag>y</tag></tags><extra>e</extra></item>`
+
+	var want item
+	if err := xml.Unmarshal([]byte(xmlText), &want); err != nil {
+		t.Fatal(err)
+	}
+	var got item
+	if err := parseOne(t, "<root>"+xmlText+"</root>", "item").Decode(&got); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode and xml.Unmarshal differ:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestDecodeCDATAAndAnyAttr(t *testing.T) {
+	type note struct {
+		Text string `xml:",cdata"`
+	}
+	type item struct {
+		ID    string     `xml:"id,attr"`
+		Extra []xml.Attr `xml:",any,attr"`
+		Note  note       `xml:"note"`
+	}
+	type singleAttr struct {
+		Lang  string   `xml:"lang,attr"`
+		Other xml.Attr `xml:",attr,any"`
+	}
+	xmlText := `<item id="7" xmlns:g="urn:g" g:price="9" lang="pl"><note>a<![CDATA[<b>]]>c</note></item>`
+
+	var want, got item
+	if err := xml.Unmarshal([]byte(xmlText), &want); err != nil {
+		t.Fatal(err)
+	}
+	elem := parseOne(t, "<root>"+xmlText+"</root>", "item")
+	if err := elem.Decode(&got); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode and xml.Unmarshal differ:\n got %+v\nwant %+v", got, want)
+	}
+	if got.Note.Text != "a<b>c" || len(got.Extra) != 3 {
+		t.Errorf("unexpected result %+v", got)
+	}
+
+	var wantSingle, gotSingle singleAttr
+	if err := xml.Unmarshal([]byte(xmlText), &wantSingle); err != nil {
+		t.Fatal(err)
+	}
+	if err := elem.Decode(&gotSingle); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if gotSingle != wantSingle {
+		t.Errorf("Decode and xml.Unmarshal differ:\n got %+v\nwant %+v", gotSingle, wantSingle)
+	}
+}
+
+func TestDecodeScalar(t *testing.T) {
+	var price float64
+	if err := parseOne(t, `<root><price> 1.5 </price></root>`, "price").Decode(&price); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if price != 1.5 {
+		t.Errorf("expected 1.5, got %v", price)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	doc := "<root>\n<item><qty>1</qty></item>\n<item><qty>many</qty></item></root>"
+	elements := parseAll(t, doc, []string{"item"})
+
+	type item struct {
+		Qty int `xml:"qty"`
+	}
+	var first item
+	if err := elements[0].Decode(&first); err != nil || first.Qty != 1 {
+		t.Fatalf("expected qty 1, got %d (%v)", first.Qty, err)
+	}
+
+	var second item
+	err := elements[1].Decode(&second)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected *DecodeError, got %v", err)
+	}
+	if decodeErr.Field != "Qty" || decodeErr.Line != 3 || decodeErr.Column != 7 {
+		t.Errorf("expected field Qty at line 3, column 7, got %q at %s", decodeErr.Field, decodeErr.Position)
+	}
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) {
+		t.Errorf("expected wrapped *strconv.NumError, got %v", decodeErr.Err)
+	}
+
+	type nested struct {
+		Item struct {
+			Qty int `xml:"qty"`
+		} `xml:"item"`
+	}
+	var n nested
+	err = parseOne(t, "<root><wrap>\n<item><qty>x</qty></item></wrap></root>", "wrap").Decode(&n)
+	if !errors.As(err, &decodeErr) || decodeErr.Field != "Item.Qty" {
+		t.Errorf("expected error for field Item.Qty, got %v", err)
+	}
+
+	type wrongName struct {
+		XMLName xml.Name `xml:"product"`
+	}
+	if err := elements[0].Decode(&wrongName{}); err == nil {
+		t.Error("expected error for mismatched XMLName")
+	}
+
+	if err := elements[0].Decode(first); err == nil {
+		t.Error("expected error for non-pointer argument")
+	}
+}
+
+func TestDecodeInvalidTags(t *testing.T) {
+	elem := parseOne(t, `<root><item/></root>`, "item")
+
+	type badXPath struct {
+		V string `xpath:"count("`
+	}
+	type badFlag struct {
+		V string `xml:"v,bogus"`
+	}
+	type badPath struct {
+		V string `xml:"a>>b"`
+	}
+	for _, v := range []any{&badXPath{}, &badFlag{}, &badPath{}} {
+		if err := elem.Decode(v); err == nil {
+			t.Errorf("expected error decoding into %T", v)
+		}
+	}
+}
+
+func TestDecodeConcurrent(t *testing.T) {
+	type item struct {
+		N     int    `xpath:"number(@id)"`
+		Label string `xpath:"concat('#', value)"`
+	}
+	parser := NewParser(context.Background(), strings.NewReader(numberedItems(200)), []string{"item"}, 0)
+
+	results := ProcessParallel(parser, 8, true, func() func(*XMLElement) (item, error) {
+		return func(elem *XMLElement) (item, error) {
+			var v item
+			err := elem.Decode(&v)
+			return v, err
+		}
+	})
+	i := 0
+	for v, err := range results {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v.N != i || v.Label != "#"+strconv.Itoa(i) {
+			t.Fatalf("expected item %d, got %+v", i, v)
+		}
+		i++
+	}
+	if i != 200 {
+		t.Errorf("expected 200 items, got %d", i)
+	}
+}
+
+// =============================================================================
+// TYPED STREAM TESTS
+// =============================================================================
+
+func TestStreamAs(t *testing.T) {
+	type item struct {
+		ID    int    `xml:"id,attr"`
+		Value string `xml:"value"`
+	}
+
+	i := 0
+	for v, err := range StreamAs[item](context.Background(), strings.NewReader(numberedItems(50)), "/root/item") {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v.ID != i || v.Value != strconv.Itoa(i) {
+			t.Fatalf("expected item %d, got %+v", i, v)
+		}
+		i++
+	}
+	if i != 50 {
+		t.Errorf("expected 50 items, got %d", i)
+	}
+}
+
+func TestStreamAsPointer(t *testing.T) {
+	type item struct {
+		ID int `xml:"id,attr"`
+	}
+	var ids []int
+	for v, err := range StreamAs[*item](context.Background(), strings.NewReader(numberedItems(3)), "item") {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids = append(ids, v.ID)
+	}
+	if fmt.Sprint(ids) != "[0 1 2]" {
+		t.Errorf("expected [0 1 2], got %v", ids)
+	}
+}
+
+func TestStreamAsErrors(t *testing.T) {
+	type item struct {
+		Qty int `xml:"qty"`
+	}
+	doc := "<root>\n<item><qty>1</qty></item>\n<item><qty>bad</qty></item>\n<item><qty>3</qty></item>\n<item><qty"
+
+	var qtys []int
+	var errs []error
+	for v, err := range StreamAs[item](context.Background(), strings.NewReader(doc), "item") {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		qtys = append(qtys, v.Qty)
+	}
+	if fmt.Sprint(qtys) != "[1 3]" {
+		t.Errorf("expected [1 3], got %v", qtys)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected a decode error and a parse error, got %v", errs)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(errs[0], &decodeErr) || decodeErr.Line != 3 {
+		t.Errorf("expected decode error on line 3, got %v", errs[0])
+	}
+	if !errors.Is(errs[1], io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF last, got %v", errs[1])
+	}
+}
+
+func TestStreamAsBreak(t *testing.T) {
+	type item struct {
+		ID int `xml:"id,attr"`
+	}
+	count := 0
+	for range StreamAs[item](context.Background(), &infiniteItemReader{}, "item") {
+		count++
+		if count == 5 {
+			break
+		}
+	}
+	if count != 5 {
+		t.Errorf("expected 5 items, got %d", count)
+	}
+}
+
+// =============================================================================
+// TYPED RESULT HELPER TESTS
+// =============================================================================
+
+const typedResultXML = `<root><item id="7" active="true" date="2024-05-01">` +
+	`<price> 12.50 </price><qty>3</qty><tag>a</tag><tag>b</tag><flag>0</flag><name>x</name>` +
+	`</item></root>`
+
+func TestElementStrings(t *testing.T) {
+	elem := parseOne(t, typedResultXML, "item")
+
+	tags, err := ElementStrings(elem.Evaluate(xpath.MustCompile("tag")))
+	if err != nil || strings.Join(tags, ",") != "a,b" {
+		t.Errorf("expected [a b], got %v (%v)", tags, err)
+	}
+	attrs, err := ElementStrings(elem.Evaluate(xpath.MustCompile("@id | @active")))
+	if err != nil || strings.Join(attrs, ",") != "7,true" {
+		t.Errorf("expected [7 true], got %v (%v)", attrs, err)
+	}
+	none, err := ElementStrings(elem.Evaluate(xpath.MustCompile("missing")))
+	if err != nil || len(none) != 0 {
+		t.Errorf("expected no strings, got %v (%v)", none, err)
+	}
+	single, err := ElementStrings(elem.Evaluate(xpath.MustCompile("concat(tag, '!')")))
+	if err != nil || strings.Join(single, ",") != "a!" {
+		t.Errorf("expected [a!], got %v (%v)", single, err)
+	}
+	if _, err := ElementStrings(elem.Evaluate(xpath.MustCompile("count(tag)"))); err == nil {
+		t.Error("expected error for a number result")
+	}
+}
+
+func TestElementFloatAndInt(t *testing.T) {
+	elem := parseOne(t, typedResultXML, "item")
+
+	if f, err := ElementFloat(elem.Evaluate(xpath.MustCompile("price"))); err != nil || f != 12.5 {
+		t.Errorf("expected 12.5 from node-set, got %v (%v)", f, err)
+	}
+	if f, err := ElementFloat(elem.Evaluate(xpath.MustCompile("sum(qty | @id)"))); err != nil || f != 10 {
+		t.Errorf("expected 10 from number, got %v (%v)", f, err)
+	}
+	if n, err := ElementInt(elem.Evaluate(xpath.MustCompile("qty"))); err != nil || n != 3 {
+		t.Errorf("expected 3 from node-set, got %v (%v)", n, err)
+	}
+	if n, err := ElementInt(elem.Evaluate(xpath.MustCompile("count(tag)"))); err != nil || n != 2 {
+		t.Errorf("expected 2 from number, got %v (%v)", n, err)
+	}
+	if n, err := ElementInt(elem.Evaluate(xpath.MustCompile("string(@id)"))); err != nil || n != 7 {
+		t.Errorf("expected 7 from string, got %v (%v)", n, err)
+	}
+
+	if _, err := ElementInt(elem.Evaluate(xpath.MustCompile("price"))); err == nil {
+		t.Error("expected parse error for '12.50' as int")
+	}
+	if _, err := ElementInt(elem.Evaluate(xpath.MustCompile("number(price)"))); err == nil {
+		t.Error("expected error for non-integral number")
+	}
+	if _, err := ElementFloat(elem.Evaluate(xpath.MustCompile("name"))); err == nil {
+		t.Error("expected parse error for 'x' as float")
+	}
+	if _, err := ElementFloat(elem.Evaluate(xpath.MustCompile("missing"))); !errors.Is(err, ErrEmptyResult) {
+		t.Errorf("expected ErrEmptyResult, got %v", err)
+	}
+	if _, err := ElementFloat(elem.Evaluate(xpath.MustCompile("boolean(tag)"))); err == nil {
+		t.Error("expected error for a boolean result")
+	}
+}
+
+func TestElementBool(t *testing.T) {
+	elem := parseOne(t, typedResultXML, "item")
+
+	if b, err := ElementBool(elem.Evaluate(xpath.MustCompile("@active"))); err != nil || !b {
+		t.Errorf("expected true from attribute, got %v (%v)", b, err)
+	}
+	if b, err := ElementBool(elem.Evaluate(xpath.MustCompile("flag"))); err != nil || b {
+		t.Errorf("expected false from '0', got %v (%v)", b, err)
+	}
+	if b, err := ElementBool(elem.Evaluate(xpath.MustCompile("count(tag) = 2"))); err != nil || !b {
+		t.Errorf("expected true from boolean, got %v (%v)", b, err)
+	}
+	if _, err := ElementBool(elem.Evaluate(xpath.MustCompile("name"))); err == nil {
+		t.Error("expected parse error for 'x' as bool")
+	}
+	if _, err := ElementBool(elem.Evaluate(xpath.MustCompile("count(tag)"))); err == nil {
+		t.Error("expected error for a number result")
+	}
+}
+
+func TestElementTime(t *testing.T) {
+	elem := parseOne(t, typedResultXML, "item")
+
+	date, err := ElementTime(elem.Evaluate(xpath.MustCompile("@date")), time.DateOnly)
+	if err != nil || !date.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected 2024-05-01, got %v (%v)", date, err)
+	}
+	if _, err := ElementTime(elem.Evaluate(xpath.MustCompile("name")), time.DateOnly); err == nil {
+		t.Error("expected parse error for 'x' as date")
+	}
+}
+
+func TestElementElements(t *testing.T) {
+	elem := parseOne(t, typedResultXML, "item")
+
+	tags, err := ElementElements(elem.Evaluate(xpath.MustCompile("tag")))
+	if err != nil || len(tags) != 2 || tags[1].InnerText() != "b" {
+		t.Errorf("expected the two tag elements, got %v (%v)", tags, err)
+	}
+	if _, err := ElementElements(elem.Evaluate(xpath.MustCompile("@id"))); err == nil {
+		t.Error("expected error for attribute nodes")
+	}
+	if _, err := ElementElements(elem.Evaluate(xpath.MustCompile("string(tag)"))); err == nil {
+		t.Error("expected error for a string result")
+	}
+}
+
+// =============================================================================
+// QUERY SET TESTS
+// =============================================================================
+
+const querySetXML = `<root xmlns:g="http://base.google.com/ns/1.0"><item id="1" g:id="G1" lang="en">` +
+	`lead<g:price currency="PLN">9.99</g:price><name>A</name><name>B<b>bold</b>C</name>` +
+	`<link href="x"/><link/><link href="y"/><![CDATA[tail]]><!--c--></item></root>`
+
+func TestQuerySetMatchesEvaluate(t *testing.T) {
+	exprs := map[string]string{
+		"names":     "name",
+		"id":        "@id",
+		"gid":       "@g:id",
+		"links":     "link/@href",
+		"nameText":  "name/text()",
+		"text":      "text()",
+		"price":     "g:price",
+		"currency":  "g:price/@currency",
+		"missing":   "missing",
+		"count":     "count(name)",
+		"string":    "string(name)",
+		"predicate": "name[2]",
+		"nested":    "name/b",
+		"spaced":    " name ",
+		"all":       "*",
+	}
+	qs, err := CompileQuerySet(exprs)
+	if err != nil {
+		t.Fatalf("CompileQuerySet failed: %v", err)
+	}
+	elem := parseOne(t, querySetXML, "item")
+
+	results := qs.Evaluate(elem)
+	if len(results) != len(exprs) {
+		t.Fatalf("expected %d results, got %d", len(exprs), len(results))
+	}
+	for name, src := range exprs {
+		expected := elem.Evaluate(xpath.MustCompile(src))
+		if !reflect.DeepEqual(results[name], expected) {
+			t.Errorf("%s (%q): expected %v, got %v", name, src, expected, results[name])
+		}
+	}
+}
+
+func TestQuerySetSimplePaths(t *testing.T) {
+	tests := []struct {
+		expr        string
+		allowPrefix bool
+		kind        queryKind
+	}{
+		{"name", false, queryChild},
+		{"@id", false, queryAttr},
+		{"link/@href", false, queryChildAttr},
+		{"name/text()", false, queryChildText},
+		{"text()", false, queryText},
+		{"g:price", true, queryChild},
+		{"g:price", false, queryXPath},
+		{"@g:id", false, queryXPath},
+		{"name/b", false, queryXPath},
+		{"name[1]", false, queryXPath},
+		{"*", false, queryXPath},
+		{"@*", false, queryXPath},
+		{"//name", false, queryXPath},
+		{"count(name)", false, queryXPath},
+		{"@xmlns", false, queryXPath},
+	}
+	for _, tt := range tests {
+		qs := &QuerySet{}
+		qs.add(tt.expr, xpath.MustCompile(tt.expr), tt.allowPrefix, nil)
+		if got := qs.queries[0].kind; got != tt.kind {
+			t.Errorf("%q (prefixes %v): expected kind %d, got %d", tt.expr, tt.allowPrefix, tt.kind, got)
+		}
+	}
+}
+
+func TestNewQuerySet(t *testing.T) {
+	exprs := []*xpath.Expr{xpath.MustCompile("name"), xpath.MustCompile("@id"), xpath.MustCompile("g:price")}
+	qs := NewQuerySet(exprs...)
+	if got := strings.Join(qs.Names(), ","); got != "name,@id,g:price" {
+		t.Errorf("expected names in argument order, got %q", got)
+	}
+
+	elem := parseOne(t, querySetXML, "item")
+	var results []any
+	for range 2 {
+		results = qs.EvaluateInto(elem, results)
+		for i, expr := range exprs {
+			if expected := elem.Evaluate(expr); !reflect.DeepEqual(results[i], expected) {
+				t.Errorf("%s: expected %v, got %v", expr, expected, results[i])
+			}
+		}
+	}
+	if got := ElementString(results[2]); got != "9.99" {
+		t.Errorf("expected g:price 9.99, got %q", got)
+	}
+}
+
+func TestCompileQuerySetError(t *testing.T) {
+	if _, err := CompileQuerySet(map[string]string{"bad": "count("}); err == nil {
+		t.Error("expected error for invalid expression")
+	}
+}
+
+func BenchmarkQuerySet(b *testing.B) {
+	xml := `<root xmlns:g="http://base.google.com/ns/1.0"><item>` +
+		`<g:OfferID>1</g:OfferID><g:ProductName>Name</g:ProductName>` +
+		`<g:ProductPrice>9.99</g:ProductPrice><g:CategoryID>5</g:CategoryID>` +
+		`<g:Description>text</g:Description><g:Image>a.jpg</g:Image></item></root>`
+	parser := NewParser(context.Background(), strings.NewReader(xml), []string{"item"}, 0)
+	node := <-parser.Stream()
+	exprs := map[string]string{
+		"id":       "g:OfferID",
+		"name":     "g:ProductName",
+		"price":    "g:ProductPrice",
+		"category": "g:CategoryID",
+	}
+
+	b.Run("Evaluate", func(b *testing.B) {
+		compiled := make([]*xpath.Expr, 0, len(exprs))
+		for _, src := range exprs {
+			compiled = append(compiled, xpath.MustCompile(src))
+		}
+		for b.Loop() {
+			for _, expr := range compiled {
+				_ = ElementString(node.Evaluate(expr))
+			}
+		}
+	})
+	b.Run("QuerySet", func(b *testing.B) {
+		qs, _ := CompileQuerySet(exprs)
+		var results []any
+		for b.Loop() {
+			results = qs.EvaluateInto(node, results)
+			for _, result := range results {
+				_ = ElementString(result)
+			}
+		}
+	})
+}
+
+// =============================================================================
+// NAMESPACE BINDING TESTS
+// =============================================================================
+
+const googleNS = "http://base.google.com/ns/1.0"
+
+// The same feed using different prefixes for the Google namespace
+var namespaceBindingDocs = []string{
+	`<feed xmlns:g="` + googleNS + `"><g:item g:status="active"><g:id>1</g:id><g:cat>a</g:cat></g:item><g:item><g:id>2</g:id></g:item></feed>`,
+	`<feed xmlns:gm="` + googleNS + `"><gm:item gm:status="active"><gm:id>1</gm:id><gm:cat>a</gm:cat></gm:item><gm:item><gm:id>2</gm:id></gm:item></feed>`,
+	`<feed><item xmlns="` + googleNS + `" xmlns:x="` + googleNS + `" x:status="active"><id>1</id><cat>a</cat></item><item xmlns="` + googleNS + `"><id>2</id></item></feed>`,
+}
+
+func TestEvaluateWithNamespaces(t *testing.T) {
+	namespaces := map[string]string{"g": googleNS}
+	for i, doc := range namespaceBindingDocs {
+		elem := parseOne(t, doc, "{"+googleNS+"}item")
+		result, err := elem.EvaluateWithNamespaces("g:id", namespaces)
+		if err != nil {
+			t.Fatalf("doc %d: %v", i, err)
+		}
+		if got := ElementString(result); got != "1" {
+			t.Errorf("doc %d: expected g:id 1, got %q", i, got)
+		}
+		status, _ := elem.EvaluateWithNamespaces("string(@g:status)", namespaces)
+		if status != "active" {
+			t.Errorf("doc %d: expected @g:status active, got %v", i, status)
+		}
+	}
+
+	elem := parseOne(t, namespaceBindingDocs[1], "gm:item")
+	if got := ElementString(elem.Evaluate(xpath.MustCompile("g:id"))); got != "" {
+		t.Errorf("expected no match for the literal prefix g, got %q", got)
+	}
+	if _, err := elem.EvaluateWithNamespaces("h:id", namespaces); err == nil {
+		t.Error("expected error for an unbound prefix")
+	}
+}
+
+func TestWithNamespaces(t *testing.T) {
+	active, err := CompileFilter("@g:status='active' and g:cat='a'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, doc := range namespaceBindingDocs {
+		parser := NewParserWithOptions(context.Background(), strings.NewReader(doc),
+			WithNamespaces(map[string]string{"g": googleNS}),
+			WithStreamSelectors("feed/g:item"),
+			WithFilter(active),
+		)
+		expr, err := parser.CompileXPath("g:id")
+		if err != nil {
+			t.Fatalf("doc %d: CompileXPath failed: %v", i, err)
+		}
+		var ids []string
+		for elem, err := range parser.All() {
+			if err != nil {
+				t.Fatalf("doc %d: %v", i, err)
+			}
+			ids = append(ids, strings.Clone(ElementString(elem.Evaluate(expr))))
+			elem.Release()
+		}
+		if strings.Join(ids, ",") != "1" {
+			t.Errorf("doc %d: expected [1], got %v", i, ids)
+		}
+	}
+
+	// The filter itself still matches the prefix literally when used without bindings
+	parser := NewParser(context.Background(), strings.NewReader(namespaceBindingDocs[1]), []string{"gm:item"}, 0, WithFilter(active))
+	count := 0
+	for range parser.Stream() {
+		count++
+	}
+	if count != 0 {
+		t.Errorf("expected unbound filter to match nothing in the gm document, got %d", count)
+	}
+}
+
+func TestCompileXPathUnboundPrefix(t *testing.T) {
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(namespaceBindingDocs[0]),
+		WithNamespaces(map[string]string{"g": googleNS}))
+	defer parser.Close()
+	if _, err := parser.CompileXPath("h:id"); err == nil {
+		t.Error("expected an error for a prefix without binding")
+	}
+	if _, err := parser.CompileXPath("g:id"); err != nil {
+		t.Errorf("unexpected error for a bound prefix: %v", err)
+	}
+
+	// Without bindings, prefixes match literally
+	parser = NewParser(context.Background(), strings.NewReader(namespaceBindingDocs[0]), nil, 0)
+	defer parser.Close()
+	if _, err := parser.CompileXPath("h:id"); err != nil {
+		t.Errorf("unexpected error without bindings: %v", err)
+	}
+}
+
+func TestWithNamespacesPrefixWildcard(t *testing.T) {
+	for i, doc := range namespaceBindingDocs {
+		parser := NewParserWithOptions(context.Background(), strings.NewReader(doc),
+			WithNamespaces(map[string]string{"g": googleNS}),
+			WithStreamSelectors("feed/g:*"),
+		)
+		count := 0
+		for elem := range parser.Stream() {
+			count++
+			elem.Release()
+		}
+		if err := parser.Err(); err != nil || count != 2 {
+			t.Errorf("doc %d: expected 2 elements, got %d (%v)", i, count, err)
+		}
+	}
+
+	// A prefix the bindings do not cover can never match
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(namespaceBindingDocs[0]),
+		WithNamespaces(map[string]string{"g": googleNS}),
+		WithStreamSelectors("h:*"),
+	)
+	for range parser.Stream() {
+	}
+	if err := parser.Err(); err == nil || !strings.Contains(err.Error(), "not bound") {
+		t.Errorf("expected an unbound prefix error, got %v", err)
+	}
+}
+
+func TestCompileQuerySetWithNamespaces(t *testing.T) {
+	namespaces := map[string]string{"g": googleNS}
+	exprs := map[string]string{
+		"id":     "g:id",
+		"status": "@g:status",
+		"cat":    "g:cat/text()",
+		"count":  "count(g:*)",
+	}
+	qs, err := CompileQuerySetWithNamespaces(exprs, namespaces)
+	if err != nil {
+		t.Fatalf("CompileQuerySetWithNamespaces failed: %v", err)
+	}
+	if qs.queries[slices.Index(qs.Names(), "id")].kind != queryChild {
+		t.Error("expected g:id to be answered in the single pass")
+	}
+
+	for i, doc := range namespaceBindingDocs {
+		elem := parseOne(t, doc, "{"+googleNS+"}item")
+		results := qs.Evaluate(elem)
+		for name, src := range exprs {
+			expr, err := xpath.CompileWithNS(src, namespaces)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := elem.Evaluate(expr)
+			if !reflect.DeepEqual(results[name], expected) {
+				t.Errorf("doc %d, %s: expected %v, got %v", i, name, expected, results[name])
+			}
+		}
+		if got := ElementString(results["id"]); got != "1" {
+			t.Errorf("doc %d: expected id 1, got %q", i, got)
+		}
+	}
+
+	if _, err := CompileQuerySetWithNamespaces(map[string]string{"x": "h:id"}, namespaces); err == nil {
+		t.Error("expected error for an unbound prefix")
+	}
+}
+
+// =============================================================================
+// NAMESPACE ACCESSOR TESTS
+// =============================================================================
+
+func TestElementNamespaceAccessors(t *testing.T) {
+	doc := `<root xmlns="urn:default" xmlns:g="urn:g">` +
+		`<g:item xmlns:h="urn:h" g:id="1" h:v="2" plain="3" xml:lang="en" xmlns:k="urn:k">` +
+		`<child xmlns=""/></g:item></root>`
+	elem := parseOne(t, doc, "g:item")
+
+	if elem.LocalName() != "item" || elem.Prefix() != "g" || elem.NamespaceURI() != "urn:g" {
+		t.Errorf("unexpected name parts %q %q %q", elem.LocalName(), elem.Prefix(), elem.NamespaceURI())
+	}
+
+	lookups := []struct {
+		prefix string
+		uri    string
+		ok     bool
+	}{
+		{"", "urn:default", true},
+		{"g", "urn:g", true},
+		{"h", "urn:h", true},
+		{"xml", "http://www.w3.org/XML/1998/namespace", true},
+		{"missing", "", false},
+	}
+	for _, l := range lookups {
+		if uri, ok := elem.LookupNamespace(l.prefix); uri != l.uri || ok != l.ok {
+			t.Errorf("LookupNamespace(%q): expected %q %v, got %q %v", l.prefix, l.uri, l.ok, uri, ok)
+		}
+	}
+
+	scope := elem.InScopeNamespaces()
+	expected := map[string]string{"": "urn:default", "g": "urn:g", "h": "urn:h", "k": "urn:k"}
+	if !reflect.DeepEqual(scope, expected) {
+		t.Errorf("expected in-scope namespaces %v, got %v", expected, scope)
+	}
+	scope["g"] = "changed"
+	if uri, _ := elem.LookupNamespace("g"); uri != "urn:g" {
+		t.Error("modifying the returned map changed the element")
+	}
+
+	child := elem.children[0].(*XMLElement)
+	if child.NamespaceURI() != "" {
+		t.Errorf("expected child in no namespace, got %q", child.NamespaceURI())
+	}
+	if _, ok := child.LookupNamespace(""); ok {
+		t.Error("expected no default namespace after xmlns=\"\"")
+	}
+	if _, ok := child.InScopeNamespaces()[""]; ok {
+		t.Error("expected undeclared default namespace to be left out")
+	}
+}
+
+func TestAttributeNamespaces(t *testing.T) {
+	doc := `<root xmlns="urn:default" xmlns:g="urn:g"><item xmlns:h="urn:h" g:id="1" h:v="2" plain="3" xml:lang="en"/></root>`
+	elem := parseOne(t, doc, "item")
+
+	expected := []XMLAttribute{
+		{Name: "xmlns:h", Value: "urn:h", LocalName: "h", NamespaceURI: "http://www.w3.org/2000/xmlns/"},
+		{Name: "g:id", Value: "1", LocalName: "id", NamespaceURI: "urn:g"},
+		{Name: "h:v", Value: "2", LocalName: "v", NamespaceURI: "urn:h"},
+		{Name: "plain", Value: "3", LocalName: "plain", NamespaceURI: ""},
+		{Name: "xml:lang", Value: "en", LocalName: "lang", NamespaceURI: "http://www.w3.org/XML/1998/namespace"},
+	}
+	if !reflect.DeepEqual(elem.Attributes, expected) {
+		t.Errorf("unexpected attributes:\n got %+v\nwant %+v", elem.Attributes, expected)
+	}
+
+	// XPath resolves attribute namespaces the same way
+	lang, err := elem.EvaluateWithNamespaces("string(@x:lang)", map[string]string{"x": "http://www.w3.org/XML/1998/namespace"})
+	if err != nil || lang != "en" {
+		t.Errorf("expected xml:lang en through a bound prefix, got %v (%v)", lang, err)
+	}
+}
+
+// =============================================================================
+// PROCESSING INSTRUCTION AND PROLOG TESTS
+// =============================================================================
+
+func TestProcessingInstructionNodes(t *testing.T) {
+	doc := `<root><item>a<?php echo 1; ?>b<!--c--><?empty?><x/></item></root>`
+	elem := parseOne(t, doc, "item")
+
+	var pis []*XMLContentNode
+	for _, child := range elem.children {
+		if c, ok := child.(*XMLContentNode); ok && c.Target() != "" {
+			pis = append(pis, c)
+		}
+	}
+	if len(pis) != 2 {
+		t.Fatalf("expected 2 processing instructions, got %d", len(pis))
+	}
+	if pis[0].Target() != "php" || pis[0].InnerText() != "echo 1; " {
+		t.Errorf("unexpected first PI: target %q, data %q", pis[0].Target(), pis[0].InnerText())
+	}
+	if pis[1].Target() != "empty" || pis[1].InnerText() != "" {
+		t.Errorf("unexpected second PI: target %q, data %q", pis[1].Target(), pis[1].InnerText())
+	}
+	if pis[0].Position().Offset != 13 {
+		t.Errorf("expected PI at offset 13, got %d", pis[0].Position().Offset)
+	}
+
+	// Neither the PI data nor the comment is text
+	if got := elem.InnerText(); got != "ab" {
+		t.Errorf("expected InnerText %q, got %q", "ab", got)
+	}
+	if got := elem.InnerXML(); got != `a<?php echo 1; ?>b<!--c--><?empty?><x/>` {
+		t.Errorf("unexpected InnerXML %q", got)
+	}
+
+	counts := map[string]float64{
+		"count(node())":    6,
+		"count(text())":    2,
+		"count(comment())": 1,
+		"count(*)":         1,
+		"count(node()[name() != '' and not(self::*)])":   2,
+		"count(node()[name() = 'php' and not(self::*)])": 1,
+	}
+	for src, want := range counts {
+		if got := elem.Evaluate(xpath.MustCompile(src)); got != want {
+			t.Errorf("%s: expected %v, got %v", src, want, got)
+		}
+	}
+	if got := elem.Evaluate(xpath.MustCompile("string(node()[2])")); got != "echo 1; " {
+		t.Errorf("expected PI string value, got %q", got)
+	}
+}
+
+func TestProcessingInstructionInCommentOnlyElement(t *testing.T) {
+	elem := parseOne(t, `<root><item><?pi data?><!--c--></item></root>`, "item")
+	if got := elem.InnerText(); got != "" {
+		t.Errorf("expected no text, got %q", got)
+	}
+}
+
+func TestProcessingInstructionNodeTest(t *testing.T) {
+	elem := parseOne(t, `<root><item><?pi data?><a>x</a><pi/><?other?></item></root>`, "item")
+
+	nodes := elem.Evaluate(xpath.MustCompile("processing-instruction()")).([]any)
+	if len(nodes) > 0 {
+		if _, ok := nodes[0].(*XMLElement); ok {
+			t.Skip("xpath does not implement the processing-instruction() node test")
+		}
+	}
+	var targets []string
+	for _, node := range nodes {
+		pi, ok := node.(*XMLContentNode)
+		if !ok || pi.nodeType != processingInstructionNode {
+			t.Fatalf("expected only processing instructions, got %v", nodes)
+		}
+		targets = append(targets, pi.Target())
+	}
+	if !slices.Equal(targets, []string{"pi", "other"}) {
+		t.Errorf("expected targets [pi other], got %v", targets)
+	}
+
+	nodes = elem.Evaluate(xpath.MustCompile("processing-instruction('pi')")).([]any)
+	if len(nodes) != 1 {
+		t.Fatalf("expected 1 processing instruction, got %v", nodes)
+	}
+	if pi, ok := nodes[0].(*XMLContentNode); !ok || pi.Target() != "pi" {
+		t.Errorf("expected the pi processing instruction, got %v", nodes[0])
+	}
+}
+
+func TestXMLDeclarationAndDocType(t *testing.T) {
+	doc := `<?xml version="1.0" encoding='ISO-8859-1' standalone="no"?>
+<!DOCTYPE rss PUBLIC "-//Netscape//DTD RSS 0.91//EN" "http://example.com/rss-0.91.dtd" [
+  <!ENTITY copy "(c)">
+]>
+<rss><item>1</item></rss>`
+	parser := NewParser(context.Background(), strings.NewReader(doc), []string{"item"}, 0)
+	if _, ok := parser.XMLDeclaration(); ok {
+		t.Error("expected no declaration before parsing")
+	}
+
+	for elem := range parser.Stream() {
+		// The prolog has been read once the first element arrives
+		decl, ok := parser.XMLDeclaration()
+		if !ok {
+			t.Fatal("expected XML declaration")
+		}
+		if want := (XMLDeclaration{Version: "1.0", Encoding: "ISO-8859-1", Standalone: "no"}); decl != want {
+			t.Errorf("expected %+v, got %+v", want, decl)
+		}
+		elem.Release()
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	docType, ok := parser.DocType()
+	if !ok {
+		t.Fatal("expected DOCTYPE")
+	}
+	want := DocType{
+		Name:           "rss",
+		PublicID:       "-//Netscape//DTD RSS 0.91//EN",
+		SystemID:       "http://example.com/rss-0.91.dtd",
+		InternalSubset: "\n  <!ENTITY copy \"(c)\">\n",
+	}
+	if docType != want {
+		t.Errorf("expected %+v, got %+v", want, docType)
+	}
+}
+
+func TestDocTypeForms(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want DocType
+	}{
+		{`<!DOCTYPE html><html/>`, DocType{Name: "html"}},
+		{`<!DOCTYPE note SYSTEM "note.dtd"><note/>`, DocType{Name: "note", SystemID: "note.dtd"}},
+		{`<!DOCTYPE note [<!ELEMENT note (#PCDATA)>]><note/>`, DocType{Name: "note", InternalSubset: "<!ELEMENT note (#PCDATA)>"}},
+	}
+	for _, tt := range tests {
+		parser := NewParser(context.Background(), strings.NewReader(tt.doc), nil, 0)
+		for range parser.Stream() {
+		}
+		got, ok := parser.DocType()
+		if !ok || got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v (%v)", tt.doc, tt.want, got, ok)
+		}
+		if _, ok := parser.XMLDeclaration(); ok {
+			t.Errorf("%s: expected no XML declaration", tt.doc)
+		}
+	}
+}
+
+// =============================================================================
+// ENCODING TESTS
+// =============================================================================
+
+// utf16Bytes encodes s as UTF-16 in the given byte order, with a byte order mark if bom is set
+func utf16Bytes(s string, bigEndian, bom bool) string {
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	b := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		if bigEndian {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	return string(b)
+}
+
+func TestInputEncodings(t *testing.T) {
+	utf16Doc := `<?xml version="1.0" encoding="UTF-16"?><root><item a="Łódź">Śląsk</item></root>`
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"utf-8", `<?xml version="1.0" encoding="UTF-8"?><root><item a="Łódź">Śląsk</item></root>`},
+		{"utf-8 bom", "\xEF\xBB\xBF" + `<?xml version="1.0" encoding="utf-8"?><root><item a="Łódź">Śląsk</item></root>`},
+		{"iso-8859-2", "<?xml version=\"1.0\" encoding=\"ISO-8859-2\"?><root><item a=\"\xA3\xF3d\xBC\">\xA6l\xB1sk</item></root>"},
+		{"windows-1250", "<?xml version='1.0' encoding='windows-1250'?>\n<root><item a=\"\xA3\xF3d\x9F\">\x8Cl\xB9sk</item></root>"},
+		{"utf-16be bom", utf16Bytes(utf16Doc, true, true)},
+		{"utf-16le bom", utf16Bytes(utf16Doc, false, true)},
+		{"utf-16le", utf16Bytes(utf16Doc, false, false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elem := parseOne(t, tt.doc, "item")
+			if got := elem.InnerText(); got != "Śląsk" {
+				t.Errorf("expected text %q, got %q", "Śląsk", got)
+			}
+			if len(elem.Attributes) != 1 || elem.Attributes[0].Value != "Łódź" {
+				t.Errorf("expected attribute %q, got %+v", "Łódź", elem.Attributes)
+			}
+		})
+	}
+}
+
+func TestUTF8ByteOrderMarkPositions(t *testing.T) {
+	// The byte order mark counts in offsets but not in columns
+	doc := "\xEF\xBB\xBF<root><item>1</item></root>"
+	parser := NewParser(context.Background(), strings.NewReader(doc), []string{"item"}, 0, WithRawCapture(), WithStrict())
+	for elem := range parser.Stream() {
+		pos := elem.Position()
+		if pos.Offset != 9 || pos.Line != 1 || pos.Column != 7 {
+			t.Errorf("expected offset 9 at line 1, column 7, got offset %d at %s", pos.Offset, pos)
+		}
+		if raw := string(elem.Raw()); raw != doc[elem.StartOffset():elem.EndOffset()] {
+			t.Errorf("expected the source bytes, got %q", raw)
+		}
+		elem.Release()
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := parseStrict("\xEF\xBB\xBF<root><1/></root>")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Offset != 9 || parseErr.Column != 7 {
+		t.Errorf("expected a violation at offset 9, column 7, got %v", err)
+	}
+}
+
+func TestDeclaredEncodingReported(t *testing.T) {
+	doc := "<?xml version=\"1.0\" encoding=\"ISO-8859-2\"?><root><item>\xB1</item></root>"
+	parser := NewParser(context.Background(), strings.NewReader(doc), []string{"item"}, 0)
+	for elem := range parser.Stream() {
+		if got := elem.InnerText(); got != "ą" {
+			t.Errorf("expected %q, got %q", "ą", got)
+		}
+		elem.Release()
+	}
+	if decl, ok := parser.XMLDeclaration(); !ok || decl.Encoding != "ISO-8859-2" {
+		t.Errorf("expected the declared encoding, got %+v", decl)
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	for _, doc := range []string{
+		`<?xml version="1.0" encoding="x-no-such-charset"?><root><item>1</item></root>`,
+		`<?xml version="1.0" encoding="UTF-16"?><root><item>1</item></root>`, // no byte order mark
+	} {
+		parser := NewParser(context.Background(), strings.NewReader(doc), []string{"item"}, 0)
+		count := 0
+		for elem := range parser.Stream() {
+			count++
+			elem.Release()
+		}
+		if count != 0 {
+			t.Errorf("expected nothing to be streamed, got %d elements", count)
+		}
+		err := parser.Err()
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || !errors.Is(err, ErrUnsupportedEncoding) {
+			t.Fatalf("expected *ParseError wrapping ErrUnsupportedEncoding, got %v", err)
+		}
+		if parseErr.Offset != 0 {
+			t.Errorf("expected offset 0, got %d", parseErr.Offset)
+		}
+	}
+}
+
+func TestRawCaptureRefusesConvertedInput(t *testing.T) {
+	// Raw could only return the UTF-8 conversion of these documents
+	for _, doc := range []string{
+		"<?xml version=\"1.0\" encoding=\"ISO-8859-2\"?><root><item>\xB1</item></root>",
+		utf16Bytes(`<root><item>ą</item></root>`, false, true),
+	} {
+		parser := NewParser(context.Background(), strings.NewReader(doc), []string{"item"}, 0, WithRawCapture())
+		count := 0
+		for elem := range parser.Stream() {
+			count++
+			elem.Release()
+		}
+		if count != 0 {
+			t.Errorf("expected nothing to be streamed, got %d elements", count)
+		}
+		if err := parser.Err(); !errors.Is(err, ErrUnsupportedEncoding) || !strings.Contains(err.Error(), "raw capture") {
+			t.Errorf("expected raw capture to be refused, got %v", err)
+		}
+	}
+
+	// UTF-8 and ASCII input are captured unchanged
+	for _, doc := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?><root><item>ą</item></root>`,
+		`<?xml version="1.0" encoding="US-ASCII"?><root><item>a</item></root>`,
+	} {
+		parser := NewParser(context.Background(), strings.NewReader(doc), []string{"item"}, 0, WithRawCapture())
+		for elem := range parser.Stream() {
+			if raw := string(elem.Raw()); raw != doc[elem.StartOffset():elem.EndOffset()] {
+				t.Errorf("expected the source bytes, got %q", raw)
+			}
+			elem.Release()
+		}
+		if err := parser.Err(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCharsetReaderOption(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="x-upper"?><root><item>abc</item></root>`
+	var labels []string
+	upper := func(label string, input io.Reader) (io.Reader, error) {
+		labels = append(labels, label)
+		b, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(strings.Replace(string(b), "abc", "ABC", 1)), nil
+	}
+
+	parser := NewParser(context.Background(), strings.NewReader(doc), []string{"item"}, 0, WithCharsetReader(upper))
+	for elem := range parser.Stream() {
+		if got := elem.InnerText(); got != "ABC" {
+			t.Errorf("expected converted text, got %q", got)
+		}
+		elem.Release()
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(labels, []string{"x-upper"}) {
+		t.Errorf("expected CharsetReader to be called once with the label, got %q", labels)
+	}
+
+	// Errors from the CharsetReader stop parsing
+	failing := func(label string, input io.Reader) (io.Reader, error) {
+		return nil, errors.New("no converter")
+	}
+	parser = NewParser(context.Background(), strings.NewReader(doc), []string{"item"}, 0, WithCharsetReader(failing))
+	for range parser.Stream() {
+	}
+	if err := parser.Err(); !errors.Is(err, ErrUnsupportedEncoding) || !strings.Contains(err.Error(), "no converter") {
+		t.Errorf("expected the CharsetReader error, got %v", err)
+	}
+
+	// UTF-8 input never reaches it
+	labels = nil
+	parser = NewParser(context.Background(), strings.NewReader(`<?xml version="1.0" encoding="utf-8"?><root><item>abc</item></root>`), []string{"item"}, 0, WithCharsetReader(upper))
+	for range parser.Stream() {
+	}
+	if len(labels) != 0 {
+		t.Errorf("expected CharsetReader not to be called, got %q", labels)
+	}
+}
+
+// =============================================================================
+// DECOMPRESSION TESTS
+// =============================================================================
+
+// Two bzip2 streams, "<root><item>b1</item>" and "<item>b2</item></root>"
+const bzip2Doc = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x69\x2e\xde\x6f\x00\x00\x02\x19\x80\x00\x00\xa0\x05\x12\x22\x94\x00\x20\x00\x21\x2a\x3d\x04\xcd\x42\x01\xa6\x9a\x14\xc4\x8f\x04\x8b\xbf\x0d\xc7\x59\xf1\x77\x24\x53\x85\x09\x06\x92\xed\xe6\xf0" +
+	"\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\xa9\xf9\xc0\xfc\x00\x00\x02\x99\x80\x00\x00\x90\x05\x12\x22\x94\x00\x20\x00\x21\x2a\x6d\x4f\x53\x23\xd4\x20\x1a\x69\xa1\x0f\x6c\xc9\x49\x17\x78\x48\xea\x1f\x17\x72\x45\x38\x50\x90\xa9\xf9\xc0\xfc"
+
+// compressedDocs returns "<root><item>1</item><item>2</item></root>" compressed in
+// every supported format, split into two members, frames or streams where possible
+func compressedDocs(t *testing.T) map[string][]byte {
+	t.Helper()
+	parts := []string{"<root><item>1</item>", "<item>2</item></root>"}
+
+	var gz bytes.Buffer
+	for _, part := range parts {
+		w := gzip.NewWriter(&gz)
+		_, _ = w.Write([]byte(part))
+		_ = w.Close()
+	}
+
+	var zs bytes.Buffer
+	for _, part := range parts {
+		w, err := zstd.NewWriter(&zs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(part))
+		_ = w.Close()
+	}
+
+	var xzBuf bytes.Buffer
+	for _, part := range parts {
+		w, err := xz.NewWriter(&xzBuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(part))
+		_ = w.Close()
+	}
+
+	return map[string][]byte{
+		"gzip":  gz.Bytes(),
+		"zstd":  zs.Bytes(),
+		"xz":    xzBuf.Bytes(),
+		"plain": []byte(strings.Join(parts, "")),
+	}
+}
+
+// parserTexts consumes parser and returns the text of every streamed element
+func parserTexts(t *testing.T, parser *Parser) []string {
+	t.Helper()
+	var texts []string
+	for elem := range parser.Stream() {
+		texts = append(texts, strings.Clone(elem.InnerText()))
+		elem.Release()
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return texts
+}
+
+func TestAutoDecompress(t *testing.T) {
+	docs := compressedDocs(t)
+	for name, doc := range docs {
+		t.Run(name, func(t *testing.T) {
+			parser := NewParserWithOptions(context.Background(), bytes.NewReader(doc), WithStreamSelectors("item"), WithAutoDecompress())
+			texts := parserTexts(t, parser)
+			if !slices.Equal(texts, []string{"1", "2"}) {
+				t.Errorf("expected [1 2], got %q", texts)
+			}
+		})
+	}
+
+	t.Run("bzip2", func(t *testing.T) {
+		parser := NewParserWithOptions(context.Background(), strings.NewReader(bzip2Doc), WithStreamSelectors("item"), WithAutoDecompress())
+		texts := parserTexts(t, parser)
+		if !slices.Equal(texts, []string{"b1", "b2"}) {
+			t.Errorf("expected [b1 b2], got %q", texts)
+		}
+	})
+}
+
+func TestAutoDecompressCorruptInput(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write([]byte(numberedItems(100)))
+	_ = w.Close()
+	doc := buf.Bytes()[:buf.Len()/2]
+	parser := NewParserWithOptions(context.Background(), bytes.NewReader(doc), WithStreamSelectors("item"), WithAutoDecompress())
+	for elem := range parser.Stream() {
+		elem.Release()
+	}
+	var parseErr *ParseError
+	if err := parser.Err(); !errors.As(err, &parseErr) {
+		t.Errorf("expected *ParseError for truncated gzip input, got %v", err)
+	}
+}
+
+func TestNewParserFromFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, doc := range compressedDocs(t) {
+		path := filepath.Join(dir, "feed."+name)
+		if err := os.WriteFile(path, doc, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		parser, err := NewParserFromFile(context.Background(), path, WithStreamSelectors("item"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		texts := parserTexts(t, parser)
+		if !slices.Equal(texts, []string{"1", "2"}) {
+			t.Errorf("%s: expected [1 2], got %q", name, texts)
+		}
+		if _, err := parser.inputCloser.(*os.File).Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+			t.Errorf("%s: expected the file to be closed after parsing, got %v", name, err)
+		}
+	}
+
+	// Close closes the file of a parser that was never consumed
+	parser, err := NewParserFromFile(context.Background(), filepath.Join(dir, "feed.plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = parser.Close()
+	if _, err := parser.inputCloser.(*os.File).Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected Close to close the file, got %v", err)
+	}
+
+	if _, err := NewParserFromFile(context.Background(), filepath.Join(dir, "missing.xml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+}
+
+// =============================================================================
+// STRICT MODE TESTS
+// =============================================================================
+
+// parseStrict parses doc with WithStrict, streaming item elements, and returns the
+// number of streamed elements and the parser's error
+func parseStrict(doc string) (int, error) {
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(doc), WithStreamSelectors("item"), WithStrict())
+	count := 0
+	for elem := range parser.Stream() {
+		count++
+		elem.Release()
+	}
+	return count, parser.Err()
+}
+
+func TestStrictModeAcceptsWellFormed(t *testing.T) {
+	doc := "\xEF\xBB\xBF" + `<?xml version="1.0"?>
+<!-- feed -->
+<rss xmlns:g="urn:g" xml:lang="en">
+	<item g:id="1" id="2"><g:price>1</g:price><é-x.1 _a="b"/></item>
+	<item><![CDATA[x]]></item>
+</rss>
+`
+	count, err := parseStrict(doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 items, got %d", count)
+	}
+}
+
+func TestStrictModeViolations(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		offset int64
+		msg    string
+	}{
+		{"mismatched end tag", `<root><item><a></b></item></root>`, 15, "end tag </b> does not match <a>"},
+		{"end tag without start", `<root></root></extra>`, 13, "end tag </extra> without start tag"},
+		{"second root", `<root/><root/>`, 7, "second root element <root>"},
+		{"text after root", `<root/>text`, 7, "text outside the root element"},
+		{"text before root", `text<root/>`, 0, "text outside the root element"},
+		{"no root", `<!-- nothing -->`, 16, "no root element"},
+		{"repeated attribute", `<root><item a="1" a="2"/></root>`, 6, "repeated attribute a in <item>"},
+		{"repeated namespaced attribute", `<root xmlns:p="urn:x" xmlns:q="urn:x"><item p:a="1" q:a="2"/></root>`, 38, "repeated attribute q:a in <item>"},
+		{"invalid element name", `<root><1item/></root>`, 6, `invalid element name "1item"`},
+		{"invalid attribute name", `<root><item -a="1"/></root>`, 6, `invalid attribute name "-a" in <item>`},
+		{"undeclared element prefix", `<root><g:item/></root>`, 6, `undeclared namespace prefix "g" in <g:item>`},
+		{"undeclared attribute prefix", `<root><item g:id="1"/></root>`, 6, `undeclared namespace prefix "g" in attribute g:id of <item>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseStrict(tt.doc)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) || !errors.Is(err, ErrNotWellFormed) {
+				t.Fatalf("expected *ParseError wrapping ErrNotWellFormed, got %v", err)
+			}
+			if parseErr.Offset != tt.offset {
+				t.Errorf("expected offset %d, got %d", tt.offset, parseErr.Offset)
+			}
+			if !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("expected %q in %q", tt.msg, err.Error())
+			}
+		})
+	}
+}
+
+func TestStrictModeStopsAtFirstViolation(t *testing.T) {
+	// Elements before the violation are still streamed
+	count, err := parseStrict(`<root><item>1</item><item>2</oops></root>`)
+	if count != 1 {
+		t.Errorf("expected 1 item before the violation, got %d", count)
+	}
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Element != "item" {
+		t.Errorf("expected violation inside <item>, got %v", err)
+	}
+
+	// Without WithStrict the same document is accepted
+	parser := NewParser(context.Background(), strings.NewReader(`<root><item>1</item><item>2</oops></root>`), []string{"item"}, 0)
+	for elem := range parser.Stream() {
+		elem.Release()
+	}
+	if err := parser.Err(); err != nil {
+		t.Errorf("expected lenient parsing by default, got %v", err)
+	}
+}
+
+// =============================================================================
+// RECOVERY MODE TESTS
+// =============================================================================
+
+// parseRecovering parses doc in recovery mode, streaming item elements, and returns
+// their outer XML and the reported problems. It fails the test if Err is not nil.
+func parseRecovering(t *testing.T, doc string) ([]string, []*ParseError) {
+	t.Helper()
+	var problems []*ParseError
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(doc), WithStreamSelectors("item"),
+		WithRecovery(func(err *ParseError) { problems = append(problems, err) }))
+	var items []string
+	for elem := range parser.Stream() {
+		items = append(items, elem.OuterXML())
+		elem.Release()
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatalf("unexpected error in recovery mode: %v", err)
+	}
+	return items, problems
+}
+
+func TestRecoveryAutoClosesMismatchedTags(t *testing.T) {
+	doc := `<rss><channel><item><title>a<b>bold</title><price>1</price></item><item>2</item></channel></rss>`
+	items, problems := parseRecovering(t, doc)
+
+	want := []string{`<item><title>a<b>bold</b></title><price>1</price></item>`, `<item>2</item>`}
+	if !slices.Equal(items, want) {
+		t.Errorf("expected %q, got %q", want, items)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+	p := problems[0]
+	if !errors.Is(p, ErrNotWellFormed) || p.Path != "/rss/channel/item/title/b" || p.Offset != 35 {
+		t.Errorf("unexpected problem %+v", p)
+	}
+}
+
+func TestRecoveryIgnoresStrayEndTag(t *testing.T) {
+	items, problems := parseRecovering(t, `<root><item>1</p>2</item></root>`)
+	if !slices.Equal(items, []string{`<item>12</item>`}) {
+		t.Errorf("unexpected items %q", items)
+	}
+	if len(problems) != 1 || problems[0].Path != "/root/item" || !strings.Contains(problems[0].Error(), "</p>") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
+
+func TestRecoveryToleratesStrayAmpersand(t *testing.T) {
+	items, problems := parseRecovering(t, "<root><item q=\"a&b\">Fish & Chips &amp; more</item></root>")
+	if !slices.Equal(items, []string{`<item q="a&amp;b">Fish &amp; Chips &amp; more</item>`}) {
+		t.Errorf("unexpected items %q", items)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+	// Positions point at the '&' itself
+	if problems[0].Offset != 16 || problems[0].Path != "/root/item" {
+		t.Errorf("unexpected attribute problem %+v", problems[0])
+	}
+	if problems[1].Offset != 25 || problems[1].Path != "/root/item" {
+		t.Errorf("unexpected text problem %+v", problems[1])
+	}
+}
+
+func TestRecoverySkipsUnparseableRecord(t *testing.T) {
+	// "< 3" is read as a start tag that swallows the item's end tag
+	doc := `<root><item>1</item><item>2 < 3</item><item>4</item><item>5</item></root>`
+	items, problems := parseRecovering(t, doc)
+
+	if !slices.Equal(items, []string{`<item>1</item>`, `<item>4</item>`, `<item>5</item>`}) {
+		t.Errorf("unexpected items %q", items)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+	if !errors.Is(problems[0], ErrNotWellFormed) || problems[0].Offset != 28 {
+		t.Errorf("unexpected problem %+v", problems[0])
+	}
+	skipped := problems[1]
+	if !errors.Is(skipped, ErrSkippedRecord) || skipped.Path != "/root/item" || skipped.ElementPosition.Offset != 20 {
+		t.Errorf("unexpected skipped record %+v", skipped)
+	}
+}
+
+func TestRecoveryTruncatedInput(t *testing.T) {
+	for _, doc := range []string{
+		`<root><item>1</item><item>2`,
+		`<root><item>1</item><item>2</it`,
+	} {
+		items, problems := parseRecovering(t, doc)
+		if !slices.Equal(items, []string{`<item>1</item>`}) {
+			t.Errorf("%s: unexpected items %q", doc, items)
+		}
+		if len(problems) != 2 || !errors.Is(problems[1], ErrSkippedRecord) || problems[1].Path != "/root/item" {
+			t.Errorf("%s: unexpected problems %v", doc, problems)
+		}
+	}
+}
+
+func TestRecoveryReportsOtherViolations(t *testing.T) {
+	doc := `<root><item a="1" a="2" g:b="3">1<g:x/></item></root><root/>`
+	items, problems := parseRecovering(t, doc)
+	if len(items) != 1 {
+		t.Errorf("expected the item to be kept, got %q", items)
+	}
+	var messages, paths []string
+	for _, p := range problems {
+		messages = append(messages, p.Err.Error())
+		paths = append(paths, p.Path)
+	}
+	want := []string{
+		"not well-formed: repeated attribute a in <item>",
+		`not well-formed: undeclared namespace prefix "g" in attribute g:b of <item>`,
+		`not well-formed: undeclared namespace prefix "g" in <g:x>`,
+		"not well-formed: second root element <root>",
+	}
+	if !slices.Equal(messages, want) {
+		t.Errorf("expected %q, got %q", want, messages)
+	}
+	// Start tag problems are reported in the element the tag opens
+	wantPaths := []string{"/root/item", "/root/item", "/root/item/g:x", "/root"}
+	if !slices.Equal(paths, wantPaths) {
+		t.Errorf("expected paths %q, got %q", wantPaths, paths)
+	}
+	if problems[2].Element != "g:x" || problems[2].ElementPosition.Offset != 33 {
+		t.Errorf("expected the problem in <g:x> at offset 33, got %+v", problems[2])
+	}
+}
+
+func TestStrictModeStrayAmpersand(t *testing.T) {
+	if _, err := parseStrict(`<root><item>a &amp; b &#38; c</item></root>`); err != nil {
+		t.Errorf("unexpected error for references: %v", err)
+	}
+	_, err := parseStrict(`<root><item>a & b</item></root>`)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || !errors.Is(err, ErrNotWellFormed) || parseErr.Offset != 14 {
+		t.Errorf("expected a violation at offset 14, got %v", err)
+	}
+}
+
+// =============================================================================
+// ATTRIBUTE TOKENIZER TESTS
+// =============================================================================
+
+func TestAttributeWhitespace(t *testing.T) {
+	doc := "<root><item\n\ta\n=\n\"1\"\r\n\tb = '2'\n\tc=\"x\ny\"\n/></root>"
+	elem := parseOne(t, doc, "item")
+
+	want := map[string]string{"a": "1", "b": "2", "c": "x\ny"}
+	if len(elem.Attributes) != len(want) {
+		t.Fatalf("expected %d attributes, got %+v", len(want), elem.Attributes)
+	}
+	for _, attr := range elem.Attributes {
+		if want[attr.Name] != attr.Value {
+			t.Errorf("attribute %s: expected %q, got %q", attr.Name, want[attr.Name], attr.Value)
+		}
+	}
+}
+
+func TestAttributeValuesContainingEquals(t *testing.T) {
+	elem := parseOne(t, `<root><item href="/p?a=b&amp;c=d" x='==' y="'"/></root>`, "item")
+	if len(elem.Attributes) != 3 || cap(elem.Attributes) < 3 {
+		t.Fatalf("expected 3 attributes, got %+v", elem.Attributes)
+	}
+	if elem.Attributes[0].Value != "/p?a=b&c=d" || elem.Attributes[1].Value != "==" || elem.Attributes[2].Value != "'" {
+		t.Errorf("unexpected values %+v", elem.Attributes)
+	}
+
+	if n := countAttributes([]byte(`href="/p?a=b&amp;c=d" x='==' y="'"`)); n != 3 {
+		t.Errorf("expected a count of 3, got %d", n)
+	}
+}
+
+func TestMalformedAttributesAreSkipped(t *testing.T) {
+	names := func(elem *XMLElement) []string {
+		var names []string
+		for _, attr := range elem.Attributes {
+			names = append(names, attr.Name+"="+attr.Value)
+		}
+		return names
+	}
+
+	// A malformed attribute no longer hides the ones after it
+	elem := parseOne(t, `<root><item a=1 b="2" checked d="4" b="5"/></root>`, "item")
+	if got := names(elem); !slices.Equal(got, []string{"b=2", "d=4"}) {
+		t.Errorf("expected [b=2 d=4], got %q", got)
+	}
+
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(`<root><item a=1 b="2" c=x&amp;y/></root>`), WithStreamSelectors("item"), WithUnquotedAttributes())
+	for elem := range parser.All() {
+		if got := names(elem); !slices.Equal(got, []string{"a=1", "b=2", "c=x&y"}) {
+			t.Errorf("expected unquoted values to be accepted, got %q", got)
+		}
+		elem.Release()
+	}
+}
+
+func TestNamespaceDeclarationsWithWhitespace(t *testing.T) {
+	doc := "<root title=\"xmlns:x='urn:wrong'\"\n xmlns:g\n =\n 'urn:g'><g:item\nxmlns='urn:d'><a/></g:item></root>"
+	elem := parseOne(t, doc, "g:item")
+	if elem.NamespaceURI() != "urn:g" {
+		t.Errorf("expected urn:g, got %q", elem.NamespaceURI())
+	}
+	if _, ok := elem.LookupNamespace("x"); ok {
+		t.Error("expected no binding from inside an attribute value")
+	}
+	if uri, _ := elem.LookupNamespace(""); uri != "urn:d" {
+		t.Errorf("expected default namespace urn:d, got %q", uri)
+	}
+}
+
+func TestStrictModeAttributeSyntax(t *testing.T) {
+	tests := []struct {
+		doc string
+		msg string
+	}{
+		{`<root><item a=1/></root>`, "unquoted value of attribute a"},
+		{`<root><item checked/></root>`, "attribute checked without value"},
+		{`<root><item a="1" checked b="2"/></root>`, "attribute checked without value"},
+		{`<root><item a="1" a='1'/></root>`, "repeated attribute a in <item>"},
+	}
+	for _, tt := range tests {
+		_, err := parseStrict(tt.doc)
+		if !errors.Is(err, ErrNotWellFormed) || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: expected %q, got %v", tt.doc, tt.msg, err)
+		}
+	}
+
+	// Unquoted values are accepted when asked for
+	parser := NewParserWithOptions(context.Background(), strings.NewReader(`<root><item a=1/></root>`), WithStrict(), WithUnquotedAttributes())
+	for range parser.Stream() {
+	}
+	if err := parser.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -19,6 +19,13 @@ import (
 
 const numIterations = 5
 
+// variant is one way of processing the test file. A run returns its duration and
+// the number of items processed.
+type variant struct {
+	name string
+	run  func(baseDir string) (time.Duration, int)
+}
+
 func main() {
 	log.Println("Starting XML Processor Test")
 
@@ -49,19 +56,59 @@ func main() {
 
 	exprs := []*xpath.Expr{exprOfferID, exprProductName, exprProductPrice, exprCategoryID}
 
+	// The same paths as a QuerySet, answered in one pass over each item's children
+	queries, err := xmlstreamer.CompileQuerySet(map[string]string{
+		"offerID":      "g:OfferID",
+		"productName":  "g:ProductName",
+		"productPrice": "g:ProductPrice",
+		"categoryID":   "g:CategoryID",
+	})
+	if err != nil {
+		log.Fatalf("Failed to compile XPath expressions: %v", err)
+	}
+
+	// The first variant is the baseline: keep it unchanged so that its numbers stay
+	// comparable between versions
+	variants := []variant{
+		{"xpath", func(baseDir string) (time.Duration, int) { return runIteration(baseDir, exprs) }},
+		{"queryset", func(baseDir string) (time.Duration, int) { return runQuerySetIteration(baseDir, queries) }},
+		{"file", func(baseDir string) (time.Duration, int) { return runFileIteration(baseDir, exprs) }},
+	}
+
+	for i, v := range variants {
+		measure(baseDir, v, i == 0)
+	}
+
+	// Write memory profile
+	memProfileFile, err := os.Create(filepath.Join(baseDir, "mem.profile"))
+	if err != nil {
+		log.Fatalf("Failed to create memory profile: %v", err)
+	}
+	runtime.GC()
+	_ = pprof.WriteHeapProfile(memProfileFile)
+	_ = memProfileFile.Close()
+
+	log.Println("XML Processor Test Completed")
+}
+
+// measure runs a variant after a warmup run and reports its statistics. With
+// profile set, the CPU profile covers its measured runs.
+func measure(baseDir string, v variant, profile bool) {
 	// Warmup run (no profiling)
-	log.Println("Warmup run...")
-	runIteration(baseDir, exprs)
+	log.Printf("[%s] Warmup run...", v.name)
+	v.run(baseDir)
 	runtime.GC()
 
 	// Start CPU profiling for the measured runs
-	cpuProfileFile, err := os.Create(filepath.Join(baseDir, "cpu.profile"))
-	if err != nil {
-		log.Fatalf("Failed to create CPU profile: %v", err)
+	if profile {
+		cpuProfileFile, err := os.Create(filepath.Join(baseDir, "cpu.profile"))
+		if err != nil {
+			log.Fatalf("Failed to create CPU profile: %v", err)
+		}
+		defer func() { _ = cpuProfileFile.Close() }()
+		_ = pprof.StartCPUProfile(cpuProfileFile)
+		defer pprof.StopCPUProfile()
 	}
-	defer func() { _ = cpuProfileFile.Close() }()
-	_ = pprof.StartCPUProfile(cpuProfileFile)
-	defer pprof.StopCPUProfile()
 
 	// Run multiple iterations
 	durations := make([]time.Duration, numIterations)
@@ -69,10 +116,10 @@ func main() {
 
 	for i := 0; i < numIterations; i++ {
 		runtime.GC() // Force GC before each run
-		elapsed, count := runIteration(baseDir, exprs)
+		elapsed, count := v.run(baseDir)
 		durations[i] = elapsed
 		totalCount = count
-		log.Printf("Run %d: %s (%.2f items/sec)", i+1, elapsed, float64(count)/elapsed.Seconds())
+		log.Printf("[%s] Run %d: %s (%.2f items/sec)", v.name, i+1, elapsed, float64(count)/elapsed.Seconds())
 	}
 
 	// Calculate statistics
@@ -87,24 +134,14 @@ func main() {
 	min := durations[0]
 	max := durations[numIterations-1]
 
-	// Write memory profile
-	memProfileFile, err := os.Create(filepath.Join(baseDir, "mem.profile"))
-	if err != nil {
-		log.Fatalf("Failed to create memory profile: %v", err)
-	}
-	runtime.GC()
-	_ = pprof.WriteHeapProfile(memProfileFile)
-	_ = memProfileFile.Close()
-
 	// Report results
-	fmt.Println("\n=== Results ===")
+	fmt.Printf("\n=== Results: %s ===\n", v.name)
 	fmt.Printf("Items processed: %d\n", totalCount)
 	fmt.Printf("Iterations: %d\n", numIterations)
 	fmt.Printf("Min:    %s (%.2f items/sec)\n", min, float64(totalCount)/min.Seconds())
 	fmt.Printf("Max:    %s (%.2f items/sec)\n", max, float64(totalCount)/max.Seconds())
 	fmt.Printf("Avg:    %s (%.2f items/sec)\n", avg, float64(totalCount)/avg.Seconds())
 	fmt.Printf("Median: %s (%.2f items/sec)\n", median, float64(totalCount)/median.Seconds())
-	log.Println("XML Processor Test Completed")
 }
 
 func runIteration(baseDir string, exprs []*xpath.Expr) (time.Duration, int) {
@@ -137,3 +174,59 @@ func runIteration(baseDir string, exprs []*xpath.Expr) (time.Duration, int) {
 
 	return time.Since(start), count
 }
+
+// runQuerySetIteration is runIteration evaluating the paths as a QuerySet
+func runQuerySetIteration(baseDir string, queries *xmlstreamer.QuerySet) (time.Duration, int) {
+	testFile, err := os.Open(filepath.Join(baseDir, "test.xml.gz"))
+	if err != nil {
+		log.Fatalf("Failed to open test.xml.gz: %v", err)
+	}
+	defer func() { _ = testFile.Close() }()
+
+	gz, err := gzip.NewReader(testFile)
+	if err != nil {
+		log.Fatalf("Failed to create gzip reader: %v", err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	reader := bufio.NewReaderSize(gz, 64*1024*1024)
+
+	start := time.Now()
+	count := 0
+
+	parser := xmlstreamer.NewParser(context.Background(), reader, []string{"item"}, 0)
+
+	var results []any
+	for node := range parser.Stream() {
+		results = queries.EvaluateInto(node, results)
+		for _, result := range results {
+			_ = xmlstreamer.ElementString(result)
+		}
+		count++
+		node.Release()
+	}
+
+	return time.Since(start), count
+}
+
+// runFileIteration is runIteration with the parser opening the file itself: the timed
+// part includes opening it and detecting and setting up gzip decompression
+func runFileIteration(baseDir string, exprs []*xpath.Expr) (time.Duration, int) {
+	start := time.Now()
+	count := 0
+
+	parser, err := xmlstreamer.NewParserFromFile(context.Background(), filepath.Join(baseDir, "test.xml.gz"), xmlstreamer.WithStreamSelectors("item"))
+	if err != nil {
+		log.Fatalf("Failed to open test.xml.gz: %v", err)
+	}
+
+	for node := range parser.Stream() {
+		for _, expr := range exprs {
+			_ = xmlstreamer.ElementString(node.Evaluate(expr))
+		}
+		count++
+		node.Release()
+	}
+
+	return time.Since(start), count
+}
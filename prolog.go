@@ -0,0 +1,150 @@
+package xmlstreamer
+
+import (
+	"bytes"
+)
+
+// XMLDeclaration holds the pseudo-attributes of the XML declaration,
+// <?xml version="1.0" encoding="UTF-8" standalone="yes"?>. Pseudo-attributes
+// missing from the declaration are "".
+type XMLDeclaration struct {
+	Version    string
+	Encoding   string
+	Standalone string // "yes" or "no"
+}
+
+// DocType holds the parts of a document type declaration,
+// <!DOCTYPE name PUBLIC "public-id" "system-id" [internal subset]>.
+// Parts missing from the declaration are "".
+type DocType struct {
+	Name           string
+	PublicID       string
+	SystemID       string
+	InternalSubset string // the text between '[' and ']', unparsed
+}
+
+// XMLDeclaration returns the XML declaration of the document. It reports false
+// if the document has none or it has not been read yet; since the declaration
+// comes first, it is available once the first element has been streamed.
+func (p *Parser) XMLDeclaration() (XMLDeclaration, bool) {
+	if decl := p.declaration.Load(); decl != nil {
+		return *decl, true
+	}
+	return XMLDeclaration{}, false
+}
+
+// DocType returns the document type declaration of the document. It reports false
+// if the document has none or it has not been read yet; since the declaration
+// precedes the root element, it is available once the first element has been streamed.
+func (p *Parser) DocType() (DocType, bool) {
+	if docType := p.docType.Load(); docType != nil {
+		return *docType, true
+	}
+	return DocType{}, false
+}
+
+// splitProcessingInstruction splits the bytes of a processing instruction,
+// <?target data?>, into its target and data
+func splitProcessingInstruction(b []byte) (target, data []byte) {
+	b = bytes.TrimPrefix(b, []byte("<?"))
+	b = bytes.TrimSuffix(b, []byte("?>"))
+	end := 0
+	for end < len(b) && !isSpace(b[end]) {
+		end++
+	}
+	return b[:end], bytes.TrimLeft(b[end:], " \t\r\n")
+}
+
+// parseXMLDeclaration parses the data of an XML declaration
+func parseXMLDeclaration(data []byte) *XMLDeclaration {
+	decl := &XMLDeclaration{}
+	for len(data) > 0 {
+		var name, value []byte
+		if name, value, data = nextPseudoAttribute(data); name == nil {
+			break
+		}
+		switch string(name) {
+		case "version":
+			decl.Version = string(value)
+		case "encoding":
+			decl.Encoding = string(value)
+		case "standalone":
+			decl.Standalone = string(value)
+		}
+	}
+	return decl
+}
+
+// nextPseudoAttribute reads one name="value" pair from the data of a processing
+// instruction and returns the remaining data. name is nil if there is no pair left.
+func nextPseudoAttribute(data []byte) (name, value, rest []byte) {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	eq := bytes.IndexByte(data, '=')
+	if eq <= 0 {
+		return nil, nil, nil
+	}
+	name = bytes.TrimRight(data[:eq], " \t\r\n")
+	data = bytes.TrimLeft(data[eq+1:], " \t\r\n")
+	value, data, ok := quoted(data)
+	if !ok {
+		return nil, nil, nil
+	}
+	return name, value, data
+}
+
+// quoted reads a literal in single or double quotes from the start of b and
+// returns its content and the bytes after the closing quote
+func quoted(b []byte) (literal, rest []byte, ok bool) {
+	if len(b) == 0 || b[0] != '"' && b[0] != '\'' {
+		return nil, b, false
+	}
+	end := bytes.IndexByte(b[1:], b[0])
+	if end == -1 {
+		return nil, b, false
+	}
+	return b[1 : end+1], b[end+2:], true
+}
+
+// parseDocType parses the bytes of a document type declaration
+func parseDocType(b []byte) *DocType {
+	b = bytes.TrimPrefix(b, []byte("<!DOCTYPE"))
+	b = bytes.TrimSuffix(b, []byte(">"))
+	b = bytes.TrimLeft(b, " \t\r\n")
+
+	docType := &DocType{}
+	end := 0
+	for end < len(b) && !isSpace(b[end]) && b[end] != '[' {
+		end++
+	}
+	docType.Name = string(b[:end])
+	b = bytes.TrimLeft(b[end:], " \t\r\n")
+
+	var literal []byte
+	var ok bool
+	switch {
+	case bytes.HasPrefix(b, []byte("PUBLIC")):
+		if literal, b, ok = quoted(bytes.TrimLeft(b[6:], " \t\r\n")); ok {
+			docType.PublicID = string(literal)
+		}
+		if literal, b, ok = quoted(bytes.TrimLeft(b, " \t\r\n")); ok {
+			docType.SystemID = string(literal)
+		}
+	case bytes.HasPrefix(b, []byte("SYSTEM")):
+		if literal, b, ok = quoted(bytes.TrimLeft(b[6:], " \t\r\n")); ok {
+			docType.SystemID = string(literal)
+		}
+	}
+
+	b = bytes.TrimLeft(b, " \t\r\n")
+	if len(b) > 0 && b[0] == '[' {
+		if end := bytes.LastIndexByte(b, ']'); end > 0 {
+			docType.InternalSubset = string(b[1:end])
+		}
+	}
+	return docType
+}
+
+// isSpace reports whether c is XML whitespace
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,308 @@
+package xmlstreamer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/wilkmaciej/xpath"
+)
+
+// QuerySet evaluates several XPath expressions against an element at once.
+// Simple paths are answered together in a single pass over the element's
+// attributes and children instead of one navigator walk per expression:
+//
+//	name           child elements
+//	@name          attributes
+//	name/@attr     attributes of child elements
+//	name/text()    text of child elements
+//	text()         text of the element
+//
+// Every other expression is evaluated with XMLElement.Evaluate. Either way the
+// results are identical to those of Evaluate.
+//
+// A QuerySet holds compiled expressions, which are not safe for concurrent use;
+// use one QuerySet per goroutine.
+type QuerySet struct {
+	names   []string
+	queries []query
+
+	// Indexes into queries of the simple paths, by the name they look up: the
+	// qualified name, or the namespace URI and local name for bound prefixes
+	children   map[string][]int       // name, name/@attr, name/text()
+	childrenNS map[expandedName][]int // the same, by namespace
+	attrs      map[string][]int       // @name
+	attrsNS    map[expandedName][]int // @name, by namespace
+	text       []int                  // text()
+}
+
+// expandedName is a name resolved to its namespace URI
+type expandedName struct {
+	space, local string
+}
+
+// nameTest is the name looked up by a simple path
+type nameTest struct {
+	name     string       // qualified name, compared literally
+	expanded expandedName // compared instead when byURI is set
+	byURI    bool
+}
+
+type queryKind uint8
+
+const (
+	queryXPath queryKind = iota
+	queryChild
+	queryChildAttr
+	queryChildText
+	queryAttr
+	queryText
+)
+
+// query is one expression of a QuerySet
+type query struct {
+	kind queryKind
+	attr nameTest    // attribute name of queryChildAttr
+	expr *xpath.Expr // evaluated for queryXPath
+}
+
+// NewQuerySet creates a QuerySet from compiled expressions, each named by its
+// source text. Whether a prefixed name is matched by prefix or by namespace URI
+// depends on how the expression was compiled, so only unprefixed simple paths
+// take the single-pass route; use CompileQuerySet to have prefixed ones included.
+func NewQuerySet(exprs ...*xpath.Expr) *QuerySet {
+	qs := &QuerySet{}
+	for _, expr := range exprs {
+		qs.add(expr.String(), expr, false, nil)
+	}
+	return qs
+}
+
+// CompileQuerySet compiles a QuerySet from a map of result names to expressions.
+// Names are returned in sorted order by Names, which is also the order of the
+// results filled in by EvaluateInto.
+func CompileQuerySet(exprs map[string]string) (*QuerySet, error) {
+	return CompileQuerySetWithNamespaces(exprs, nil)
+}
+
+// CompileQuerySetWithNamespaces is like CompileQuerySet, but compiles the
+// expressions with xpath.CompileWithNS so that prefixes bound in namespaces match
+// by namespace URI, in the single-pass lookups as well as in XPath.
+func CompileQuerySetWithNamespaces(exprs map[string]string, namespaces map[string]string) (*QuerySet, error) {
+	names := make([]string, 0, len(exprs))
+	for name := range exprs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	qs := &QuerySet{}
+	for _, name := range names {
+		var expr *xpath.Expr
+		var err error
+		if len(namespaces) == 0 {
+			expr, err = xpath.Compile(exprs[name])
+		} else {
+			expr, err = xpath.CompileWithNS(exprs[name], namespaces)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xmlstreamer: invalid expression for %q: %w", name, err)
+		}
+		// Prefixes in an expression compiled without namespaces are matched
+		// literally, just like the single-pass lookups do
+		qs.add(name, expr, true, namespaces)
+	}
+	return qs, nil
+}
+
+// add appends a named expression, indexing it if it is a simple path.
+// Prefixes bound in namespaces match by URI; other prefixes are only accepted in
+// simple paths if literalPrefixes is set.
+func (qs *QuerySet) add(name string, expr *xpath.Expr, literalPrefixes bool, namespaces map[string]string) {
+	src := strings.TrimSpace(expr.String())
+	child, rest, nested := strings.Cut(src, "/")
+	parseName := func(s string) (nameTest, bool) {
+		return newNameTest(s, literalPrefixes, namespaces)
+	}
+
+	q := query{kind: queryXPath, expr: expr}
+	var test nameTest
+	var ok bool
+	switch {
+	case src == "text()":
+		q.kind = queryText
+	case strings.HasPrefix(src, "@"):
+		if test, ok = parseName(src[1:]); ok && !nested {
+			q.kind = queryAttr
+		}
+	default:
+		if test, ok = parseName(child); !ok {
+			break
+		}
+		switch {
+		case !nested:
+			q.kind = queryChild
+		case rest == "text()":
+			q.kind = queryChildText
+		case strings.HasPrefix(rest, "@"):
+			if q.attr, ok = parseName(rest[1:]); ok {
+				q.kind = queryChildAttr
+			}
+		}
+	}
+
+	index := len(qs.queries)
+	qs.names = append(qs.names, name)
+	qs.queries = append(qs.queries, q)
+	switch q.kind {
+	case queryText:
+		qs.text = append(qs.text, index)
+	case queryAttr:
+		if test.byURI {
+			qs.attrsNS = appendIndex(qs.attrsNS, test.expanded, index)
+		} else {
+			qs.attrs = appendIndex(qs.attrs, test.name, index)
+		}
+	case queryChild, queryChildAttr, queryChildText:
+		if test.byURI {
+			qs.childrenNS = appendIndex(qs.childrenNS, test.expanded, index)
+		} else {
+			qs.children = appendIndex(qs.children, test.name, index)
+		}
+	}
+}
+
+// appendIndex adds a query index to an index map, creating the map if needed
+func appendIndex[K comparable](m map[K][]int, key K, index int) map[K][]int {
+	if m == nil {
+		m = make(map[K][]int)
+	}
+	m[key] = append(m[key], index)
+	return m
+}
+
+// newNameTest parses a plain name test that can be compared with element and
+// attribute names directly. Namespace declarations are left to xpath.
+func newNameTest(s string, literalPrefixes bool, namespaces map[string]string) (nameTest, bool) {
+	if s == "" || strings.HasPrefix(s, "xmlns") {
+		return nameTest{}, false
+	}
+	if c := s[0]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80) {
+		return nameTest{}, false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameByte(s[i]) || s[i] == '*' {
+			return nameTest{}, false
+		}
+	}
+
+	prefix, local, prefixed := strings.Cut(s, ":")
+	if !prefixed {
+		return nameTest{name: s}, true
+	}
+	if prefix == "" || local == "" || strings.IndexByte(local, ':') != -1 {
+		return nameTest{}, false
+	}
+	if uri, bound := namespaces[prefix]; bound {
+		return nameTest{name: s, expanded: expandedName{uri, local}, byURI: true}, true
+	}
+	return nameTest{name: s}, literalPrefixes
+}
+
+// matchesAttribute reports whether attr passes the test
+func (test *nameTest) matchesAttribute(attr *XMLAttribute) bool {
+	if !test.byURI {
+		return attr.Name == test.name
+	}
+	return test.expanded == expandedName{attr.NamespaceURI, attr.LocalName}
+}
+
+// Names returns the names of the expressions in the order of EvaluateInto's results
+func (qs *QuerySet) Names() []string {
+	return qs.names
+}
+
+// Evaluate evaluates every expression against e and returns the results by name
+func (qs *QuerySet) Evaluate(e *XMLElement) map[string]any {
+	results := qs.EvaluateInto(e, nil)
+	m := make(map[string]any, len(results))
+	for i, result := range results {
+		m[qs.names[i]] = result
+	}
+	return m
+}
+
+// EvaluateInto evaluates every expression against e and stores the results in the
+// order of Names, reusing results if it has enough capacity. It returns the results.
+func (qs *QuerySet) EvaluateInto(e *XMLElement, results []any) []any {
+	if cap(results) < len(qs.queries) {
+		results = make([]any, len(qs.queries))
+	}
+	results = results[:len(qs.queries)]
+
+	for i := range qs.queries {
+		if q := &qs.queries[i]; q.kind == queryXPath {
+			results[i] = e.Evaluate(q.expr)
+		} else {
+			results[i] = []any{}
+		}
+	}
+
+	if len(qs.attrs) > 0 || len(qs.attrsNS) > 0 {
+		for i := range e.Attributes {
+			attr := &e.Attributes[i]
+			for _, index := range qs.attrs[attr.Name] {
+				results[index] = append(results[index].([]any), attr)
+			}
+			if len(qs.attrsNS) > 0 {
+				for _, index := range qs.attrsNS[expandedName{attr.NamespaceURI, attr.LocalName}] {
+					results[index] = append(results[index].([]any), attr)
+				}
+			}
+		}
+	}
+
+	if len(qs.children) == 0 && len(qs.childrenNS) == 0 && len(qs.text) == 0 {
+		return results
+	}
+	for _, child := range e.children {
+		switch c := child.(type) {
+		case *XMLContentNode:
+			if c.nodeType == xpath.TextNode {
+				for _, index := range qs.text {
+					results[index] = append(results[index].([]any), c)
+				}
+			}
+		case *XMLElement:
+			for _, index := range qs.children[c.Name] {
+				results[index] = qs.queries[index].appendChildResult(results[index].([]any), c)
+			}
+			if len(qs.childrenNS) > 0 {
+				for _, index := range qs.childrenNS[expandedName{c.namespaceURI, c.localName}] {
+					results[index] = qs.queries[index].appendChildResult(results[index].([]any), c)
+				}
+			}
+		}
+	}
+	return results
+}
+
+// appendChildResult appends the nodes a child path selects from the child element c
+func (q *query) appendChildResult(nodes []any, c *XMLElement) []any {
+	switch q.kind {
+	case queryChild:
+		nodes = append(nodes, c)
+	case queryChildAttr:
+		for i := range c.Attributes {
+			if q.attr.matchesAttribute(&c.Attributes[i]) {
+				nodes = append(nodes, &c.Attributes[i])
+			}
+		}
+	case queryChildText:
+		for _, grandchild := range c.children {
+			if t, ok := grandchild.(*XMLContentNode); ok && t.nodeType == xpath.TextNode {
+				nodes = append(nodes, t)
+			}
+		}
+	}
+	return nodes
+}
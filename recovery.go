@@ -0,0 +1,107 @@
+package xmlstreamer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/orisano/gosax"
+)
+
+// ErrSkippedRecord is wrapped by the *ParseError passed to the WithRecovery callback
+// when a streamed element is dropped instead of being delivered
+var ErrSkippedRecord = errors.New("skipped record")
+
+// violation handles a well-formedness violation: in recovery mode it is reported and
+// parsing continues, otherwise it is returned to stop parsing
+func (p *Parser) violation(err *ParseError) error {
+	if p.report == nil {
+		return err
+	}
+	p.report(err)
+	return nil
+}
+
+// checkText checks the text of an event for stray '&' and, outside the root
+// element, for anything but whitespace
+func (p *Parser) checkText(state *parseState, text []byte) error {
+	if len(state.stack) == 0 {
+		if err := state.checkTextOutsideRoot(text); err != nil {
+			return p.violation(state.parseError(err))
+		}
+	}
+	if i := findStrayAmpersand(text); i != -1 {
+		// The '&' is kept as text
+		return p.violation(state.parseErrorAt(state.positionAt(text, i), errStrayAmpersand))
+	}
+	return nil
+}
+
+// recoverEndTag handles an end tag that does not close the innermost open element.
+// If an enclosing element has its name, the elements inside that one are closed
+// just before the end tag and the element itself by it; otherwise the tag is ignored.
+func (p *Parser) recoverEndTag(state *parseState, emit emitFunc, endTag []byte, err error) error {
+	p.report(state.parseError(err))
+
+	name, _ := gosax.Name(endTag)
+	i := len(state.stack) - 1
+	for i >= 0 && state.stack[i].Name != string(name) {
+		i--
+	}
+	if i < 0 {
+		return nil
+	}
+	for len(state.stack) > i+1 {
+		if err := p.closeElement(state, emit, state.offset); err != nil {
+			return err
+		}
+	}
+	return p.closeElement(state, emit, state.offset+int64(len(endTag)))
+}
+
+// resync closes a record containing an unparseable tag when a start tag with the
+// same name arrives: the tag has likely swallowed the record's end tag, and the new
+// element is the next record rather than part of the broken one
+func (p *Parser) resync(state *parseState, emit emitFunc, name string) error {
+	for i := 0; i < state.brokenDepth; i++ {
+		if !state.stack[i].streamed {
+			continue
+		}
+		if state.stack[i].Name == name {
+			for len(state.stack) > i {
+				if err := p.closeElement(state, emit, state.offset); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// endOfInput handles input that ends while the document is incomplete. In recovery
+// mode the problem is reported, followed by every streamed element left open, which
+// is dropped; parsing then ends without error.
+func (p *Parser) endOfInput(state *parseState, err error) error {
+	if p.report == nil {
+		return state.parseError(err)
+	}
+	p.report(state.parseError(err))
+	for i, elem := range state.stack {
+		if elem.streamed && elem.parent == nil {
+			p.report(state.skippedRecord(elem, state.stack[:i]))
+		}
+	}
+	return nil
+}
+
+// skippedRecord returns the error reporting that the streamed element elem, whose
+// open ancestors are ancestors, has been dropped
+func (state *parseState) skippedRecord(elem *XMLElement, ancestors []*XMLElement) *ParseError {
+	return &ParseError{
+		Position:        state.position(),
+		Err:             fmt.Errorf("%w <%s>", ErrSkippedRecord, elem.Name),
+		Element:         elem.Name,
+		ElementPosition: elem.position,
+		Path:            elementPath(append(ancestors[:len(ancestors):len(ancestors)], elem)),
+	}
+}
@@ -0,0 +1,383 @@
+package xmlstreamer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// selector is a compiled stream selector.
+// Supported syntax is a small subset of XPath location paths:
+//
+//	item            any element named item (same as //item)
+//	channel/item    item whose parent is channel, at any depth
+//	/rss/channel/item  absolute path from the document root
+//	//offers/offer  offer whose parent is offers, at any depth
+//	feed//entry     entry anywhere below feed
+//	*               any element; usable as any step
+//	{http://base.google.com/ns/1.0}item  item in the given namespace, whatever its prefix
+//	{}item          item in no namespace
+//	g:*             any element in the namespace bound to g with WithNamespaces
+//	item[@status='active']  item passing a filter predicate (see Filter)
+//
+// Predicates on the last step may test children and text; predicates on earlier
+// steps are limited to attribute tests, which are known when the ancestor starts.
+type selector struct {
+	steps []selectorStep
+
+	// final is the condition the selected element itself must satisfy: the
+	// predicates of the last step combined with the parser's filters, or nil
+	final *predicate
+}
+
+// selectorStep is one name test of a selector
+type selectorStep struct {
+	name string // qualified element name, "*" matches any element
+
+	// Clark notation steps ({URI}localName) match on the resolved namespace
+	// instead of the prefixed name; localName may be "*"
+	clark        bool
+	namespaceURI string
+	localName    string
+
+	// descendant is set when the step is preceded by "//" (or, for the first step,
+	// when the selector is relative) and allows any number of elements in between
+	descendant bool
+
+	// predicate holds the step's [...] predicates, or nil
+	predicate *predicate
+}
+
+// compileSelector parses a selector expression
+func compileSelector(expr string) (*selector, error) {
+	rest := expr
+	descendant := true
+	if strings.HasPrefix(rest, "//") {
+		rest = rest[2:]
+	} else if strings.HasPrefix(rest, "/") {
+		rest = rest[1:]
+		descendant = false
+	}
+
+	sel := &selector{}
+	for {
+		end, err := selectorStepEnd(rest)
+		if err != nil {
+			return nil, fmt.Errorf("xmlstreamer: invalid stream selector %q: %w", expr, err)
+		}
+		step, err := parseSelectorStepWithPredicates(rest[:end])
+		if err != nil {
+			return nil, fmt.Errorf("xmlstreamer: invalid stream selector %q: %w", expr, err)
+		}
+		step.descendant = descendant
+		sel.steps = append(sel.steps, step)
+		if end == len(rest) {
+			for _, step := range sel.steps[:len(sel.steps)-1] {
+				if step.predicate != nil && step.predicate.needsContent() {
+					return nil, fmt.Errorf("xmlstreamer: invalid stream selector %q: only attribute tests are allowed in predicates of ancestor steps", expr)
+				}
+			}
+			sel.final = sel.steps[len(sel.steps)-1].predicate
+			return sel, nil
+		}
+
+		rest = rest[end+1:]
+		descendant = false
+		if strings.HasPrefix(rest, "/") {
+			rest = rest[1:]
+			descendant = true
+		}
+	}
+}
+
+// selectorStepEnd returns the index of the '/' that ends the first step of s, or len(s).
+// Slashes inside {URI} parts, predicates and quoted literals do not end a step.
+func selectorStepEnd(s string) (int, error) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{', '\'', '"':
+			end, err := skipLiteral(s, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth < 0 {
+				return 0, errors.New("unexpected ']'")
+			}
+		case '/':
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	if depth != 0 {
+		return 0, errors.New("missing ']'")
+	}
+	return len(s), nil
+}
+
+// skipLiteral returns the index of the character that closes the quoted literal or
+// {URI} part starting at s[i]
+func skipLiteral(s string, i int) (int, error) {
+	closing := s[i]
+	if closing == '{' {
+		closing = '}'
+	}
+	end := strings.IndexByte(s[i+1:], closing)
+	if end == -1 {
+		return 0, fmt.Errorf("missing %q", closing)
+	}
+	return i + 1 + end, nil
+}
+
+// parseSelectorStepWithPredicates parses a name test followed by any number of [...] predicates
+func parseSelectorStepWithPredicates(s string) (selectorStep, error) {
+	// The name ends at the first '[' outside {URI} parts and quoted literals
+	nameEnd := len(s)
+	for i := 0; i < nameEnd; i++ {
+		switch s[i] {
+		case '{', '\'', '"':
+			end, err := skipLiteral(s, i)
+			if err != nil {
+				return selectorStep{}, err
+			}
+			i = end
+		case '[':
+			nameEnd = i
+		}
+	}
+
+	step, err := parseSelectorStep(s[:nameEnd])
+	if err != nil {
+		return step, err
+	}
+
+	rest := s[nameEnd:]
+	for rest != "" {
+		if rest[0] != '[' {
+			return step, fmt.Errorf("unexpected %q after predicate", rest)
+		}
+		// Find the matching ']', skipping quoted literals
+		end, depth := -1, 0
+		for i := 0; i < len(rest) && end == -1; i++ {
+			switch rest[i] {
+			case '\'', '"':
+				closing, err := skipLiteral(rest, i)
+				if err != nil {
+					return step, err
+				}
+				i = closing
+			case '[':
+				depth++
+			case ']':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end == -1 {
+			return step, errors.New("missing ']'")
+		}
+		pred, err := parsePredicate(rest[1:end])
+		if err != nil {
+			return step, err
+		}
+		step.predicate = step.predicate.and(pred)
+		rest = rest[end+1:]
+	}
+	return step, nil
+}
+
+// parseSelectorStep parses a single name test, either a plain name or {URI}localName
+func parseSelectorStep(name string) (selectorStep, error) {
+	if name == "" {
+		return selectorStep{}, errors.New("empty step")
+	}
+	if name[0] != '{' {
+		// A prefix may be followed by "*" to match any element in its namespace
+		if prefix, localName, ok := strings.Cut(name, ":"); ok && localName == "*" {
+			if !isNCName(prefix) {
+				return selectorStep{}, fmt.Errorf("invalid name %q", name)
+			}
+		} else if name != "*" && !isQName(name) {
+			return selectorStep{}, fmt.Errorf("invalid name %q", name)
+		}
+		return selectorStep{name: name}, nil
+	}
+
+	end := strings.IndexByte(name, '}')
+	localName := name[end+1:]
+	if localName != "*" && !isNCName(localName) {
+		return selectorStep{}, fmt.Errorf("invalid local name in %q", name)
+	}
+	return selectorStep{
+		name:         name,
+		clark:        true,
+		namespaceURI: name[1:end],
+		localName:    localName,
+	}, nil
+}
+
+// bindNamespaces returns the step with a prefixed name whose prefix is bound in
+// namespaces turned into Clark notation, so that it matches by namespace URI
+// whatever prefix the document uses. Unbound prefixes are matched literally.
+func (step selectorStep) bindNamespaces(namespaces map[string]string) selectorStep {
+	if step.clark {
+		return step
+	}
+	prefix, localName, ok := strings.Cut(step.name, ":")
+	if !ok {
+		return step
+	}
+	namespaceURI, bound := namespaces[prefix]
+	if !bound {
+		return step
+	}
+	step.clark = true
+	step.namespaceURI = namespaceURI
+	step.localName = localName
+	return step
+}
+
+// matches reports whether elem, whose open ancestors are stack, is selected
+func (sel *selector) matches(stack []*XMLElement, elem *XMLElement) bool {
+	return sel.matchStep(len(sel.steps)-1, stack, elem, len(stack))
+}
+
+// matchStep reports whether step i matches the element at depth j of the path
+// formed by stack followed by elem, and all preceding steps match its ancestors
+func (sel *selector) matchStep(i int, stack []*XMLElement, elem *XMLElement, j int) bool {
+	step := &sel.steps[i]
+	current := elem
+	if j < len(stack) {
+		current = stack[j]
+	}
+	if !step.matchesName(current) {
+		return false
+	}
+	// The last step's predicates are part of sel.final, evaluated by the caller.
+	// Earlier steps only test attributes, so the result is never unknown.
+	if i < len(sel.steps)-1 && step.predicate != nil && step.predicate.eval(current, false) != triTrue {
+		return false
+	}
+
+	if i == 0 {
+		// A leading "/" anchors the first step at the document element
+		return step.descendant || j == 0
+	}
+	if !step.descendant {
+		return j > 0 && sel.matchStep(i-1, stack, elem, j-1)
+	}
+	for k := j - 1; k >= 0; k-- {
+		if sel.matchStep(i-1, stack, elem, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesName reports whether the step's name test accepts elem
+func (step *selectorStep) matchesName(elem *XMLElement) bool {
+	if step.clark {
+		return step.namespaceURI == elem.namespaceURI && (step.localName == "*" || step.localName == elem.localName)
+	}
+	return step.name == "*" || step.name == elem.Name
+}
+
+// selectorSet holds the compiled stream selectors of a parser, indexed by the
+// name of their last step so most elements are rejected with one map lookup
+type selectorSet struct {
+	byName      map[string][]*selector // last step is a qualified name
+	byLocalName map[string][]*selector // last step is in Clark notation
+	wildcard    []*selector            // last step is "*" or {URI}*
+}
+
+// newSelectorSet compiles exprs, returning nil if there are none.
+// Every selected element must additionally pass all of filters.
+// Prefixes bound in namespaces match by namespace URI in both.
+func newSelectorSet(exprs []string, filters []*Filter, namespaces map[string]string) (*selectorSet, error) {
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+	set := &selectorSet{byName: make(map[string][]*selector, len(exprs))}
+	for _, expr := range exprs {
+		sel, err := compileSelector(expr)
+		if err != nil {
+			return nil, err
+		}
+		if len(namespaces) > 0 {
+			for i := range sel.steps {
+				step := &sel.steps[i]
+				predicate := step.predicate.bindNamespaces(namespaces)
+				*step = step.bindNamespaces(namespaces)
+				step.predicate = predicate
+			}
+			sel.final = sel.steps[len(sel.steps)-1].predicate
+		}
+		// A prefix:* step cannot be matched literally, since no element is named "*"
+		for _, step := range sel.steps {
+			if !step.clark && strings.HasSuffix(step.name, ":*") {
+				return nil, fmt.Errorf("xmlstreamer: invalid stream selector %q: prefix in %q is not bound with WithNamespaces", expr, step.name)
+			}
+		}
+		for _, filter := range filters {
+			sel.final = sel.final.and(filter.root.bindNamespaces(namespaces))
+		}
+
+		last := &sel.steps[len(sel.steps)-1]
+		switch {
+		case last.name == "*" || last.localName == "*":
+			set.wildcard = append(set.wildcard, sel)
+		case last.clark:
+			if set.byLocalName == nil {
+				set.byLocalName = make(map[string][]*selector)
+			}
+			set.byLocalName[last.localName] = append(set.byLocalName[last.localName], sel)
+		default:
+			set.byName[last.name] = append(set.byName[last.name], sel)
+		}
+	}
+	return set, nil
+}
+
+// match reports whether elem, whose start tag has just been read, may be streamed.
+// If the decision depends on content not read yet, pending holds the conditions
+// of which at least one must hold once elem is closed (see matchClosed).
+func (set *selectorSet) match(stack []*XMLElement, elem *XMLElement) (matched bool, pending []*predicate) {
+	if set == nil {
+		return false, nil
+	}
+	for _, candidates := range [...][]*selector{set.byName[elem.Name], set.byLocalName[elem.localName], set.wildcard} {
+		for _, sel := range candidates {
+			if !sel.matches(stack, elem) {
+				continue
+			}
+			if sel.final == nil {
+				return true, nil
+			}
+			switch sel.final.eval(elem, false) {
+			case triTrue:
+				return true, nil
+			case triUnknown:
+				pending = append(pending, sel.final)
+			}
+		}
+	}
+	return len(pending) > 0, pending
+}
+
+// matchClosed decides, once elem is closed, whether it passes any of the pending conditions
+func matchClosed(elem *XMLElement, pending []*predicate) bool {
+	for _, pred := range pending {
+		if pred.eval(elem, true) == triTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package xmlstreamer
+
+import (
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/wilkmaciej/xpath"
+)
+
+// OuterXML returns the markup of the element, including its own start and end tags.
+// Namespace prefixes declared on ancestors are redeclared on the element so the
+// result is well-formed on its own.
+func (e *XMLElement) OuterXML() string {
+	return string(e.appendOuterXML(nil))
+}
+
+// InnerXML returns the markup of the element's children, without its own tags.
+// Namespace declarations inherited from ancestors are not repeated.
+func (e *XMLElement) InnerXML() string {
+	var buf []byte
+	for _, child := range e.children {
+		buf = appendNode(buf, child)
+	}
+	return string(buf)
+}
+
+// WriteTo writes the result of OuterXML to w. It implements io.WriterTo.
+func (e *XMLElement) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(e.appendOuterXML(nil))
+	return int64(n), err
+}
+
+// appendOuterXML appends the markup of e to buf, declaring every namespace in
+// scope that is not already declared by one of e's attributes
+func (e *XMLElement) appendOuterXML(buf []byte) []byte {
+	var inherited []string
+	for prefix, uri := range e.namespaces {
+		if uri == "" || e.declaresPrefix(prefix) {
+			continue
+		}
+		inherited = append(inherited, prefix)
+	}
+	slices.Sort(inherited)
+
+	buf = append(buf, '<')
+	buf = append(buf, e.Name...)
+	for _, prefix := range inherited {
+		buf = append(buf, " xmlns"...)
+		if prefix != "" {
+			buf = append(buf, ':')
+			buf = append(buf, prefix...)
+		}
+		buf = appendAttributeValue(buf, e.namespaces[prefix])
+	}
+	return e.appendContent(buf)
+}
+
+// declaresPrefix reports whether one of e's attributes declares prefix ("" for the default namespace)
+func (e *XMLElement) declaresPrefix(prefix string) bool {
+	for i := range e.Attributes {
+		name := e.Attributes[i].Name
+		if prefix == "" && name == "xmlns" || prefix != "" && strings.HasPrefix(name, "xmlns:") && name[6:] == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// appendContent appends the attributes, children and end tag of e to buf,
+// whose last bytes are the start of e's start tag
+func (e *XMLElement) appendContent(buf []byte) []byte {
+	for i := range e.Attributes {
+		buf = append(buf, ' ')
+		buf = append(buf, e.Attributes[i].Name...)
+		buf = appendAttributeValue(buf, e.Attributes[i].Value)
+	}
+	if len(e.children) == 0 {
+		return append(buf, "/>"...)
+	}
+	buf = append(buf, '>')
+	for _, child := range e.children {
+		buf = appendNode(buf, child)
+	}
+	buf = append(buf, "</"...)
+	buf = append(buf, e.Name...)
+	return append(buf, '>')
+}
+
+// appendNode appends the markup of a child node to buf
+func appendNode(buf []byte, node XMLNode) []byte {
+	switch n := node.(type) {
+	case *XMLElement:
+		buf = append(buf, '<')
+		buf = append(buf, n.Name...)
+		return n.appendContent(buf)
+	case *XMLContentNode:
+		text := n.InnerText()
+		switch {
+		case n.nodeType == xpath.CommentNode:
+			buf = append(buf, "<!--"...)
+			buf = append(buf, text...)
+			return append(buf, "-->"...)
+		case n.nodeType == processingInstructionNode:
+			buf = append(buf, "<?"...)
+			buf = append(buf, n.target...)
+			if text != "" {
+				buf = append(buf, ' ')
+				buf = append(buf, text...)
+			}
+			return append(buf, "?>"...)
+		case n.cdata:
+			buf = append(buf, "<![CDATA["...)
+			// "]]>" cannot appear inside a section, so split it across two
+			buf = append(buf, strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>")...)
+			return append(buf, "]]>"...)
+		default:
+			return appendEscapedText(buf, text)
+		}
+	}
+	return buf
+}
+
+// appendEscapedText appends character data to buf, escaping markup characters.
+// '\r' is escaped so it is not normalized away when the result is parsed again.
+func appendEscapedText(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '&':
+			buf = append(buf, "&amp;"...)
+		case '<':
+			buf = append(buf, "&lt;"...)
+		case '>':
+			buf = append(buf, "&gt;"...)
+		case '\r':
+			buf = append(buf, "&#xD;"...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
+
+// appendAttributeValue appends `="value"` to buf. Whitespace other than spaces is
+// written as character references because attribute value normalization would
+// otherwise turn it into spaces.
+func appendAttributeValue(buf []byte, s string) []byte {
+	buf = append(buf, `="`...)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '&':
+			buf = append(buf, "&amp;"...)
+		case '<':
+			buf = append(buf, "&lt;"...)
+		case '"':
+			buf = append(buf, "&quot;"...)
+		case '\t':
+			buf = append(buf, "&#x9;"...)
+		case '\n':
+			buf = append(buf, "&#xA;"...)
+		case '\r':
+			buf = append(buf, "&#xD;"...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '"')
+}
+
+var _ io.WriterTo = (*XMLElement)(nil)
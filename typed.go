@@ -0,0 +1,32 @@
+package xmlstreamer
+
+import (
+	"context"
+	"io"
+	"iter"
+)
+
+// StreamAs parses r and decodes every element matched by selector into a T, as
+// with XMLElement.Decode. Elements are released once decoded, so T must not hold
+// on to them. opts configure the underlying parser as in NewParserWithOptions.
+//
+// A value that fails to decode is yielded together with its *DecodeError, which
+// carries the position of the offending element, and iteration continues; a parse
+// error is yielded last with the zero T. Breaking out of the loop stops parsing.
+func StreamAs[T any](ctx context.Context, r io.Reader, selector string, opts ...Option) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		parser := NewParserWithOptions(ctx, r, append([]Option{WithStreamSelectors(selector)}, opts...)...)
+		for elem, err := range parser.All() {
+			var value T
+			if err != nil {
+				yield(value, err)
+				return
+			}
+			err = elem.Decode(&value)
+			elem.Release()
+			if !yield(value, err) {
+				return
+			}
+		}
+	}
+}
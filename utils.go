@@ -1,5 +1,14 @@
 package xmlstreamer
 
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // ElementString extracts a string from an XPath Evaluate result.
 // For node-set results, it returns the InnerText of the first node.
 // For string results, it returns the string directly.
@@ -26,3 +35,145 @@ func ElementString(input any) string {
 		return ""
 	}
 }
+
+// ErrEmptyResult is returned by the typed result helpers when a node-set holds no
+// nodes and there is therefore no value to convert
+var ErrEmptyResult = errors.New("xmlstreamer: empty node-set")
+
+// ElementStrings returns the text of every node of a node-set result, or a
+// one-element slice for a string result. An empty node-set yields an empty slice.
+func ElementStrings(input any) ([]string, error) {
+	switch v := input.(type) {
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, node := range v {
+			s, err := nodeString(node)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	case string:
+		return []string{v}, nil
+	}
+	return nil, resultTypeError(input, "strings")
+}
+
+// ElementFloat converts a number result, or the text of a string or the first
+// node of a node-set, to a float64
+func ElementFloat(input any) (float64, error) {
+	if f, ok := input.(float64); ok {
+		return f, nil
+	}
+	s, err := resultString(input, "float")
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("xmlstreamer: %w", err)
+	}
+	return f, nil
+}
+
+// ElementInt converts an integral number result, or the text of a string or the
+// first node of a node-set, to an int
+func ElementInt(input any) (int, error) {
+	if f, ok := input.(float64); ok {
+		if f != math.Trunc(f) || f < math.MinInt || f >= math.MaxInt {
+			return 0, fmt.Errorf("xmlstreamer: %v is not an int", f)
+		}
+		return int(f), nil
+	}
+	s, err := resultString(input, "int")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("xmlstreamer: %w", err)
+	}
+	return n, nil
+}
+
+// ElementBool returns a boolean result, or parses the text of a string or the
+// first node of a node-set with strconv.ParseBool ("true", "false", "1", "0", ...)
+func ElementBool(input any) (bool, error) {
+	if b, ok := input.(bool); ok {
+		return b, nil
+	}
+	s, err := resultString(input, "bool")
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return false, fmt.Errorf("xmlstreamer: %w", err)
+	}
+	return b, nil
+}
+
+// ElementTime parses the text of a string result or of the first node of a
+// node-set with time.Parse and the given layout
+func ElementTime(input any, layout string) (time.Time, error) {
+	s, err := resultString(input, "time")
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("xmlstreamer: %w", err)
+	}
+	return t, nil
+}
+
+// ElementElements returns the elements of a node-set result. It fails if the
+// result is not a node-set or holds attributes, text or comments.
+func ElementElements(input any) ([]*XMLElement, error) {
+	nodes, ok := input.([]any)
+	if !ok {
+		return nil, resultTypeError(input, "elements")
+	}
+	elements := make([]*XMLElement, 0, len(nodes))
+	for _, node := range nodes {
+		elem, ok := node.(*XMLElement)
+		if !ok {
+			return nil, fmt.Errorf("xmlstreamer: node-set holds %T, not only elements", node)
+		}
+		elements = append(elements, elem)
+	}
+	return elements, nil
+}
+
+// resultString returns a string result, or the text of the first node of a node-set
+func resultString(input any, target string) (string, error) {
+	switch v := input.(type) {
+	case []any:
+		if len(v) == 0 {
+			return "", ErrEmptyResult
+		}
+		return nodeString(v[0])
+	case string:
+		return v, nil
+	}
+	return "", resultTypeError(input, target)
+}
+
+// nodeString returns the text of a node from a node-set result
+func nodeString(node any) (string, error) {
+	switch n := node.(type) {
+	case *XMLElement:
+		return n.InnerText(), nil
+	case *XMLContentNode:
+		return n.InnerText(), nil
+	case *XMLAttribute:
+		return n.Value, nil
+	}
+	return "", fmt.Errorf("xmlstreamer: unexpected node %T", node)
+}
+
+// resultTypeError reports an Evaluate result that cannot be converted to target
+func resultTypeError(input any, target string) error {
+	return fmt.Errorf("xmlstreamer: cannot convert %T result to %s", input, target)
+}
@@ -0,0 +1,151 @@
+package xmlstreamer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/orisano/gosax"
+)
+
+// ErrNotWellFormed is wrapped by the *ParseError a parser created with WithStrict
+// reports for the first well-formedness violation in the document
+var ErrNotWellFormed = errors.New("not well-formed")
+
+var (
+	errTextOutsideRoot = fmt.Errorf("%w: text outside the root element", ErrNotWellFormed)
+	errStrayAmpersand  = fmt.Errorf("%w: '&' does not start a reference", ErrNotWellFormed)
+	errNoRootElement   = fmt.Errorf("%w: no root element", ErrNotWellFormed)
+)
+
+// errInvalidElementName reports a start tag whose name is not a valid name
+func errInvalidElementName(name string) error {
+	return fmt.Errorf("%w: invalid element name %q", ErrNotWellFormed, name)
+}
+
+// checkStartElement checks the namespace prefixes and attributes of an element that
+// has just been opened; its name has been checked by the caller. elem's namespaces
+// and attributes must already be set.
+func (state *parseState) checkStartElement(elem *XMLElement) error {
+	if len(state.stack) == 0 {
+		if state.rootSeen {
+			return fmt.Errorf("%w: second root element <%s>", ErrNotWellFormed, elem.Name)
+		}
+		state.rootSeen = true
+	}
+
+	if elem.prefix != "" && elem.prefix != "xml" && elem.namespaces[elem.prefix] == "" {
+		return fmt.Errorf("%w: undeclared namespace prefix %q in <%s>", ErrNotWellFormed, elem.prefix, elem.Name)
+	}
+
+	for i := range elem.Attributes {
+		attr := &elem.Attributes[i]
+		if !isQName(attr.Name) {
+			return fmt.Errorf("%w: invalid attribute name %q in <%s>", ErrNotWellFormed, attr.Name, elem.Name)
+		}
+		if strings.IndexByte(attr.Name, ':') != -1 && attr.NamespaceURI == "" {
+			prefix, _, _ := strings.Cut(attr.Name, ":")
+			return fmt.Errorf("%w: undeclared namespace prefix %q in attribute %s of <%s>", ErrNotWellFormed, prefix, attr.Name, elem.Name)
+		}
+		// Attributes repeating a qualified name are dropped by parseAttributes, but two
+		// prefixes bound to the same namespace make different names equal too
+		for j := range i {
+			other := &elem.Attributes[j]
+			if attr.NamespaceURI != "" && other.NamespaceURI == attr.NamespaceURI && other.LocalName == attr.LocalName {
+				return fmt.Errorf("%w: repeated attribute %s in <%s>", ErrNotWellFormed, attr.Name, elem.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkEndTag checks that an end tag closes the innermost open element
+func (state *parseState) checkEndTag(endTag []byte) error {
+	name, _ := gosax.Name(endTag)
+	if len(state.stack) == 0 {
+		return fmt.Errorf("%w: end tag </%s> without start tag", ErrNotWellFormed, name)
+	}
+	if open := state.stack[len(state.stack)-1]; string(name) != open.Name {
+		return fmt.Errorf("%w: end tag </%s> does not match <%s>", ErrNotWellFormed, name, open.Name)
+	}
+	return nil
+}
+
+// checkTextOutsideRoot checks that text before or after the root element is whitespace
+func (state *parseState) checkTextOutsideRoot(text []byte) error {
+	if len(bytes.Trim(text, " \t\r\n")) > 0 {
+		return errTextOutsideRoot
+	}
+	return nil
+}
+
+// isQName reports whether s is a valid qualified name: a name, optionally with a
+// prefix separated by a single colon
+func isQName(s string) bool {
+	prefix, local, prefixed := strings.Cut(s, ":")
+	if !prefixed {
+		return isNCName(s)
+	}
+	return isNCName(prefix) && isNCName(local)
+}
+
+// isNCName reports whether s is a valid XML name without colons
+func isNCName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == ':' || !isNameRune(r, i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNameRune reports whether r may appear in an XML name, at its start if first is set
+// (NameStartChar and NameChar of the XML 1.0 specification, fifth edition)
+func isNameRune(r rune, first bool) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+		return true
+	case r >= '0' && r <= '9', r == '-', r == '.':
+		return !first
+	case r < 0xC0:
+		return r == 0xB7 && !first
+	case r <= 0x2FF:
+		return r != 0xD7 && r != 0xF7
+	case r <= 0x36F:
+		return !first
+	case r <= 0x1FFF:
+		return r != 0x37E
+	case r == 0x200C, r == 0x200D:
+		return true
+	case r == 0x203F, r == 0x2040:
+		return !first
+	case r >= 0x2070 && r <= 0x218F, r >= 0x2C00 && r <= 0x2FEF, r >= 0x3001 && r <= 0xD7FF,
+		r >= 0xF900 && r <= 0xFDCF, r >= 0xFDF0 && r <= 0xFFFD, r >= 0x10000 && r <= 0xEFFFF:
+		return true
+	}
+	return false
+}
+
+// findStrayAmpersand returns the index of the first '&' in b that does not start an
+// entity or character reference the parser decodes, or -1 if there is none
+func findStrayAmpersand(b []byte) int {
+	for i := 0; ; {
+		amp := bytes.IndexByte(b[i:], '&')
+		if amp == -1 {
+			return -1
+		}
+		i += amp
+		semi := referenceEnd(b[i:])
+		if semi == -1 {
+			return i
+		}
+		if _, ok := decodeReference(b[i+1 : i+semi]); !ok {
+			return i
+		}
+		i += semi + 1
+	}
+}